@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Implements Config.AutoInstall. Returns true if the service was installed (or
+// would have been, if DryRun is set), in which case the service itself must
+// not be run.
+func (info *Info) autoInstall() (bool, error) {
+	if !info.Config.AutoInstall {
+		return false, nil
+	}
+
+	b, installed, ok := info.autoInstallBackend()
+	if !ok || installed {
+		return false, nil
+	}
+
+	if !info.Config.ForceInstall && !confirm(os.Stdin, os.Stderr, fmt.Sprintf("Install %s as a service?", info.Name)) {
+		return false, nil
+	}
+
+	if info.Config.DryRun {
+		return true, describeInstall(os.Stdout, b, info)
+	}
+
+	err := b.Install(info)
+	if err != nil {
+		return true, fmt.Errorf("cannot install service: %v", err)
+	}
+
+	info.logger().Info("service installed", "service", info.Name)
+	return true, b.Start(info)
+}
+
+// Asks the user a yes/no question. Returns false unless the user answers yes.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// Describes what installing the service using b would do, for Config.DryRun.
+func describeInstall(w io.Writer, b ServiceBackend, info *Info) error {
+	sb, ok := b.(*SystemdBackend)
+	if !ok {
+		_, err := fmt.Fprintf(w, "Would install service %s using %T.\n", info.Name, b)
+		return err
+	}
+
+	gen, err := generator("systemd")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Would write %s:\n", sb.unitPath(info))
+	err = gen(w, info)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Would run: systemctl daemon-reload && systemctl enable %s && systemctl start %s\n", info.Name, info.Name)
+	return err
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The state of an installed service as reported by a ServiceBackend.
+type ServiceState int
+
+const (
+	StateUnknown      ServiceState = iota // The state could not be determined.
+	StateStopped                          // The service is not running.
+	StateStartPending                     // The service is starting.
+	StateStopPending                      // The service is stopping.
+	StateRunning                          // The service is running.
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateStartPending:
+		return "start pending"
+	case StateStopPending:
+		return "stop pending"
+	case StateRunning:
+		return "running"
+	default:
+		return "unknown"
+	}
+}
+
+// Manages the installation and control of a service via an OS service
+// manager. The service commands which can be specified in Config.Command are
+// implemented by calling the corresponding method of a ServiceBackend.
+//
+// This package provides WindowsBackend, SystemdBackend, OpenRCBackend and
+// LaunchdBackend. Applications may provide their own implementations, for
+// example for testing.
+type ServiceBackend interface {
+	// Installs the service so that it is started automatically.
+	Install(info *Info) error
+
+	// Removes a previously installed service.
+	Remove(info *Info) error
+
+	// Starts an installed service.
+	Start(info *Info) error
+
+	// Stops a running service.
+	Stop(info *Info) error
+
+	// Queries the state of an installed service.
+	Status(info *Info) (ServiceState, error)
+}
+
+// An optional interface which a ServiceBackend can implement to support the
+// "update" service command.
+type ServiceUpdater interface {
+	// Updates the metadata (e.g. title and description) of an installed
+	// service in place, without removing and reinstalling it.
+	Update(info *Info) error
+}
+
+// A ServiceBackend which manages services using the Windows service control
+// manager. It is only supported on Windows. It also implements ServiceUpdater.
+type WindowsBackend struct{}
+
+// Writes a service file (for example, a systemd unit file or init script)
+// which can be used to run the service described by info.
+type GeneratorFunc func(w io.Writer, info *Info) error
+
+var (
+	generatorsMutex sync.Mutex
+	generators      = map[string]GeneratorFunc{}
+)
+
+// Registers a service file generator for the given init system (e.g.
+// "systemd"). Backends which need to write a service file when installing a
+// service use the generator registered for their init system. Generators are
+// normally registered by the subpackage implementing them, so that importing
+// that subpackage is sufficient to enable installation.
+func RegisterGenerator(initSystem string, gen GeneratorFunc) {
+	generatorsMutex.Lock()
+	defer generatorsMutex.Unlock()
+	generators[initSystem] = gen
+}
+
+func generator(initSystem string) (GeneratorFunc, error) {
+	generatorsMutex.Lock()
+	defer generatorsMutex.Unlock()
+	gen, ok := generators[initSystem]
+	if !ok {
+		return nil, fmt.Errorf("no service file generator registered for %s", initSystem)
+	}
+	return gen, nil
+}
+
+// Implements a service command registered with RegisterCommand.
+type CommandFunc func(info *Info) error
+
+var (
+	commandsMutex sync.Mutex
+	commands      = map[string]CommandFunc{}
+)
+
+// Registers an additional value for Config.Command. Commands are normally
+// registered by the subpackage implementing them, so that importing that
+// subpackage is sufficient to make the command available. Registered commands
+// take precedence over those built in.
+func RegisterCommand(name string, f CommandFunc) {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	commands[name] = f
+}
+
+func registeredCommand(name string) CommandFunc {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	return commands[name]
+}
+
+// Executes Config.Command using the configured backend. Returns true if a
+// command was executed, in which case the service itself must not be run.
+func (info *Info) runCommand() (bool, error) {
+	if info.Config.Command == "" {
+		return false, nil
+	}
+
+	if f := registeredCommand(info.Config.Command); f != nil {
+		return true, f(info)
+	}
+
+	b := info.Backend
+	if b == nil {
+		var err error
+		b, err = defaultBackend()
+		if err != nil {
+			return true, err
+		}
+	}
+
+	switch info.Config.Command {
+	case "install":
+		if info.Config.DryRun {
+			return true, describeInstall(os.Stdout, b, info)
+		}
+		return true, b.Install(info)
+	case "remove":
+		return true, b.Remove(info)
+	case "start":
+		return true, b.Start(info)
+	case "stop":
+		return true, b.Stop(info)
+	case "restart":
+		err := b.Stop(info)
+		if err != nil {
+			return true, err
+		}
+		return true, b.Start(info)
+	case "update":
+		u, ok := b.(ServiceUpdater)
+		if !ok {
+			return true, fmt.Errorf("service backend does not support updating services")
+		}
+		return true, u.Update(info)
+	case "status":
+		return true, printStatus(os.Stdout, b, info)
+	case "install-unit":
+		sb, ok := info.Backend.(*SystemdBackend)
+		if !ok {
+			sb = &SystemdBackend{}
+		}
+		return true, sb.Install(info)
+	case "sysvinit":
+		return true, writeServiceFile("sysvinit", filepath.Join("/etc/init.d", info.Name), 0755, info)
+	default:
+		return true, fmt.Errorf("unknown service command: %q", info.Config.Command)
+	}
+}
+
+// Returned by the "status" command when the service is not running, or its
+// state cannot be determined, so that the process can exit with a status
+// reflecting the state of the service.
+type statusError struct {
+	state ServiceState
+	err   error // Why the state could not be determined, if it could not.
+}
+
+func (e *statusError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("cannot determine state of service: %v", e.err)
+	}
+	return fmt.Sprintf("service is %v", e.state)
+}
+
+func (e *statusError) exitCode() int {
+	switch e.state {
+	case StateStopped:
+		return 1
+	case StateStartPending, StateStopPending:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Writes the state of the service to w, either as text or, if Config.JSON is
+// set, as a JSON object. Returns a *statusError if the service is not running
+// or its state cannot be determined; in the latter case, which is reported as
+// StateUnknown, nothing is written.
+func printStatus(w io.Writer, b ServiceBackend, info *Info) error {
+	state, err := b.Status(info)
+	if err != nil {
+		return &statusError{StateUnknown, err}
+	}
+
+	if info.Config.JSON {
+		err = json.NewEncoder(w).Encode(struct {
+			Name    string `json:"name"`
+			State   string `json:"state"`
+			Running bool   `json:"running"`
+		}{info.Name, state.String(), state == StateRunning})
+	} else {
+		_, err = fmt.Fprintf(w, "%s: %v\n", info.Name, state)
+	}
+	if err != nil {
+		return err
+	}
+
+	if state != StateRunning {
+		return &statusError{state: state}
+	}
+
+	return nil
+}
+
+// Runs an external service management tool, returning an error including its
+// output if it fails.
+func runTool(name string, args ...string) error {
+	_, err := runToolOutput(name, args...)
+	return err
+}
+
+func runToolOutput(name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return stdout.Bytes(), fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+		}
+		return stdout.Bytes(), fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Writes a service file generated by the generator for initSystem to path.
+// Fails if the file already exists.
+func writeServiceFile(initSystem, path string, mode os.FileMode, info *Info) error {
+	gen, err := generator(initSystem)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = gen(&buf, info)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("service %s already exists", info.Name)
+		}
+		return err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
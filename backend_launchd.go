@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// A ServiceBackend which manages services using macOS launchd. The service's
+// Name is used as its launchd label.
+//
+// Installation requires a generator to be registered for "launchd" (see
+// RegisterGenerator).
+type LaunchdBackend struct {
+	// The directory in which property lists are installed. Defaults to
+	// "/Library/LaunchDaemons".
+	PlistDir string
+}
+
+func (b *LaunchdBackend) plistPath(info *Info) string {
+	dir := b.PlistDir
+	if dir == "" {
+		dir = "/Library/LaunchDaemons"
+	}
+	return filepath.Join(dir, info.Name+".plist")
+}
+
+func (b *LaunchdBackend) Install(info *Info) error {
+	path := b.plistPath(info)
+	err := writeServiceFile("launchd", path, 0644, info)
+	if err != nil {
+		return err
+	}
+
+	return runTool("launchctl", "load", "-w", path)
+}
+
+func (b *LaunchdBackend) Remove(info *Info) error {
+	path := b.plistPath(info)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	err := runTool("launchctl", "unload", "-w", path)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (b *LaunchdBackend) Start(info *Info) error {
+	return runTool("launchctl", "start", info.Name)
+}
+
+func (b *LaunchdBackend) Stop(info *Info) error {
+	return runTool("launchctl", "stop", info.Name)
+}
+
+func (b *LaunchdBackend) Status(info *Info) (ServiceState, error) {
+	out, err := runToolOutput("launchctl", "list", info.Name)
+	if err != nil {
+		return StateUnknown, err
+	}
+
+	// The output includes a PID entry only if the job is running.
+	if bytes.Contains(out, []byte(`"PID" =`)) {
+		return StateRunning, nil
+	}
+
+	return StateStopped, nil
+}
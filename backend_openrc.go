@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// A ServiceBackend which manages services using OpenRC.
+//
+// Installation requires a generator to be registered for "openrc" (see
+// RegisterGenerator).
+type OpenRCBackend struct {
+	// The directory in which init scripts are installed. Defaults to
+	// "/etc/init.d".
+	InitDir string
+
+	// The runlevel to which the service is added on installation. Defaults to
+	// "default".
+	Runlevel string
+}
+
+func (b *OpenRCBackend) scriptPath(info *Info) string {
+	dir := b.InitDir
+	if dir == "" {
+		dir = "/etc/init.d"
+	}
+	return filepath.Join(dir, info.Name)
+}
+
+func (b *OpenRCBackend) runlevel() string {
+	if b.Runlevel == "" {
+		return "default"
+	}
+	return b.Runlevel
+}
+
+func (b *OpenRCBackend) Install(info *Info) error {
+	err := writeServiceFile("openrc", b.scriptPath(info), 0755, info)
+	if err != nil {
+		return err
+	}
+
+	return runTool("rc-update", "add", info.Name, b.runlevel())
+}
+
+func (b *OpenRCBackend) Remove(info *Info) error {
+	path := b.scriptPath(info)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	err := runTool("rc-update", "del", info.Name, b.runlevel())
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (b *OpenRCBackend) Start(info *Info) error {
+	return runTool("rc-service", info.Name, "start")
+}
+
+func (b *OpenRCBackend) Stop(info *Info) error {
+	return runTool("rc-service", info.Name, "stop")
+}
+
+func (b *OpenRCBackend) Status(info *Info) (ServiceState, error) {
+	_, err := runToolOutput("rc-service", info.Name, "status")
+	if err == nil {
+		return StateRunning, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		return StateStopped, nil
+	}
+
+	return StateUnknown, err
+}
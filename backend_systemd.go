@@ -0,0 +1,87 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A ServiceBackend which manages services using systemd.
+//
+// Installation requires a generator to be registered for "systemd" (see
+// RegisterGenerator).
+type SystemdBackend struct {
+	// The directory in which unit files are installed. Defaults to
+	// "/etc/systemd/system".
+	UnitDir string
+}
+
+func (b *SystemdBackend) unitPath(info *Info) string {
+	dir := b.UnitDir
+	if dir == "" {
+		dir = "/etc/systemd/system"
+	}
+	return filepath.Join(dir, info.Name+".service")
+}
+
+func (b *SystemdBackend) Install(info *Info) error {
+	err := writeServiceFile("systemd", b.unitPath(info), 0644, info)
+	if err != nil {
+		return err
+	}
+
+	err = runTool("systemctl", "daemon-reload")
+	if err != nil {
+		return err
+	}
+
+	return runTool("systemctl", "enable", info.Name)
+}
+
+func (b *SystemdBackend) Remove(info *Info) error {
+	path := b.unitPath(info)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	err := runTool("systemctl", "disable", info.Name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+
+	return runTool("systemctl", "daemon-reload")
+}
+
+func (b *SystemdBackend) Start(info *Info) error {
+	return runTool("systemctl", "start", info.Name)
+}
+
+func (b *SystemdBackend) Stop(info *Info) error {
+	return runTool("systemctl", "stop", info.Name)
+}
+
+func (b *SystemdBackend) Status(info *Info) (ServiceState, error) {
+	out, err := runToolOutput("systemctl", "show", "-p", "ActiveState", info.Name)
+	if err != nil {
+		return StateUnknown, err
+	}
+
+	state := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(out)), "ActiveState="))
+	switch state {
+	case "active", "reloading":
+		return StateRunning, nil
+	case "inactive", "failed":
+		return StateStopped, nil
+	case "activating":
+		return StateStartPending, nil
+	case "deactivating":
+		return StateStopPending, nil
+	default:
+		return StateUnknown, nil
+	}
+}
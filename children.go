@@ -0,0 +1,30 @@
+package service
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// Held while starting a child with StartCommand and while the child reaper
+// decides which children to reap, so that a child which exits immediately is
+// not reaped before it has been recorded.
+var childMutex sync.Mutex
+
+// The PIDs of the children started with StartCommand.
+var ownChildren = map[int]bool{}
+
+// Starts cmd, as for cmd.Start. If Config.SubReaper is set, the service reaps
+// child processes as they exit; children started using this function are
+// excluded, so that cmd.Wait can be used to wait for them as usual.
+func StartCommand(cmd *exec.Cmd) error {
+	childMutex.Lock()
+	defer childMutex.Unlock()
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	ownChildren[cmd.Process.Pid] = true
+	return nil
+}
@@ -0,0 +1,57 @@
+// Command service-ctl controls a running service through the management
+// socket opened when Config.ManagementSocket is set.
+//
+// Usage:
+//
+//	service-ctl -socket <path> [status|reload|stop]
+//
+// The response of the service is printed as JSON. The command exits with
+// status 1 if the service reports that the command failed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/hlandau/service.v3"
+)
+
+func main() {
+	socket := flag.String("socket", "", "path of the management socket of the service")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -socket <path> [status|reload|stop]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cmd := "status"
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		cmd = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *socket == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	resp, err := service.SendManagementCommand(*socket, cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service-ctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(resp)
+
+	if !resp.OK {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Returns the fields of Config which are applicable to the current platform
+// and can be represented in JSON. Fields containing interface types, such as
+// StopSignals and Credentials, cannot be decoded from JSON and are omitted.
+func jsonConfigFields() []reflect.StructField {
+	var fields []reflect.StructField
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("json") == "-" || !UsingPlatform(f.Tag.Get("platform")) || hasInterface(f.Type) {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func hasInterface(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array, reflect.Pointer:
+		return hasInterface(t.Elem())
+	case reflect.Map:
+		return hasInterface(t.Key()) || hasInterface(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasInterface(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Encodes the configuration as a JSON object keyed by field name. Fields which
+// are not applicable to the current platform are omitted, as are StopSignals
+// and Credentials, which cannot be represented in JSON.
+func (c Config) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	v := reflect.ValueOf(c)
+	for i, f := range jsonConfigFields() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(v.FieldByIndex(f.Index).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Decodes a JSON object as produced by MarshalJSON into the configuration.
+// Fields not present in the object are left unchanged; those present are
+// recorded in ExplicitlySet. Fields which are not
+// applicable to the current platform are ignored, so that the same
+// configuration can be used on all platforms; other unknown fields are an
+// error.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for name, raw := range m {
+		f, ok := t.FieldByName(name)
+		if !ok || !f.IsExported() || f.Tag.Get("json") == "-" || hasInterface(f.Type) {
+			return fmt.Errorf("unknown configuration field: %q", name)
+		}
+
+		if !UsingPlatform(f.Tag.Get("platform")) {
+			continue
+		}
+
+		err := json.Unmarshal(raw, v.FieldByIndex(f.Index).Addr().Interface())
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		c.markSet(name)
+	}
+
+	return nil
+}
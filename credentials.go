@@ -0,0 +1,132 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Returns the credentials passed to the service by systemd using
+// LoadCredential= or SetCredential=, keyed by credential name. systemd places
+// each credential in a file in the directory named by the
+// CREDENTIALS_DIRECTORY environment variable. If that variable is not set, an
+// empty map is returned.
+//
+// As the credentials directory is generally not accessible after privileges
+// are dropped or the service is chrooted, this should be called before calling
+// Manager.DropPrivileges.
+func Credentials() (map[string][]byte, error) {
+	creds := map[string][]byte{}
+
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return creds, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credentials directory: %v", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read credential %q: %v", e.Name(), err)
+		}
+
+		creds[e.Name()] = b
+	}
+
+	return creds, nil
+}
+
+// A source from which a credential can be read. See Config.Credentials.
+type CredentialSource interface {
+	ReadCredential() ([]byte, error)
+}
+
+// A credential read from the named environment variable.
+type EnvCredential string
+
+func (c EnvCredential) ReadCredential() ([]byte, error) {
+	v, ok := os.LookupEnv(string(c))
+	if !ok {
+		return nil, fmt.Errorf("environment variable %q is not set", string(c))
+	}
+
+	return []byte(v), nil
+}
+
+// A credential read from the file at the given path.
+type FileCredential string
+
+func (c FileCredential) ReadCredential() ([]byte, error) {
+	return os.ReadFile(string(c))
+}
+
+// A credential passed by systemd with the given name; see Credentials.
+type SystemdCredential string
+
+func (c SystemdCredential) ReadCredential() ([]byte, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil, fmt.Errorf("no credentials were passed by systemd")
+	}
+
+	if filepath.Base(string(c)) != string(c) {
+		return nil, fmt.Errorf("invalid credential name: %q", string(c))
+	}
+
+	return os.ReadFile(filepath.Join(dir, string(c)))
+}
+
+// Returns the source of the named credential: the source configured in
+// Config.Credentials, or otherwise the systemd credential of the same name.
+func (info *Info) credentialSource(name string) CredentialSource {
+	if src, ok := info.Config.Credentials[name]; ok && src != nil {
+		return src
+	}
+
+	return SystemdCredential(name)
+}
+
+// Reads the credentials configured in Config.Credentials, so that they remain
+// available once privileges have been dropped. Credentials which cannot be
+// read are not an error here; reading them is attempted again when they are
+// requested.
+func (info *Info) loadCredentials() {
+	for name, src := range info.Config.Credentials {
+		if src == nil {
+			continue
+		}
+
+		b, err := src.ReadCredential()
+		if err != nil {
+			continue
+		}
+
+		if info.credentials == nil {
+			info.credentials = map[string][]byte{}
+		}
+		info.credentials[name] = b
+	}
+}
+
+// Returns the named credential, from those loaded by loadCredentials if
+// possible.
+func (info *Info) getCredential(name string) ([]byte, error) {
+	if b, ok := info.credentials[name]; ok {
+		return b, nil
+	}
+
+	b, err := info.credentialSource(name).ReadCredential()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credential %q: %v", name, err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,27 @@
+// Package apparmor allows a process to transition to an AppArmor profile.
+package apparmor
+
+import "errors"
+
+// Returned if AppArmor is not enabled or not supported on the current
+// platform.
+var ErrNotSupported = errors.New("AppArmor not supported")
+
+// Returns true if AppArmor is enabled on the system.
+func Enabled() bool {
+	return enabled()
+}
+
+// Arranges for the calling thread to transition to the named profile when it
+// next calls execve(2). The transition happens on exec rather than
+// immediately because AppArmor confines individual threads, and a Go process
+// has many; exec replaces all of them with a single confined thread.
+//
+// The caller must therefore lock the goroutine to its OS thread with
+// runtime.LockOSThread before calling SetExecProfile and keep it locked until
+// it execs.
+//
+// Only supported on Linux. Returns ErrNotSupported if AppArmor is not enabled.
+func SetExecProfile(profile string) error {
+	return setExecProfile(profile)
+}
@@ -0,0 +1,37 @@
+package apparmor
+
+import (
+	"fmt"
+	"os"
+)
+
+func enabled() bool {
+	_, err := os.Stat("/sys/kernel/security/apparmor")
+	return err == nil
+}
+
+func setExecProfile(profile string) error {
+	if !enabled() {
+		return ErrNotSupported
+	}
+
+	// Newer kernels provide an AppArmor-specific directory, as several LSMs
+	// may be stacked; older ones only have the shared attribute.
+	path := "/proc/thread-self/attr/apparmor/exec"
+	if _, err := os.Stat(path); err != nil {
+		path = "/proc/thread-self/attr/exec"
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte("exec " + profile))
+	if err != nil {
+		return fmt.Errorf("cannot set AppArmor profile %q: %v", profile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package apparmor
+
+func enabled() bool {
+	return false
+}
+
+func setExecProfile(profile string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Checks that a directory is safe to chroot into as a process which will run
+// as the given UID. The directory must not be world-writable, must not be
+// group-writable unless its group is root, and must be owned by root or by
+// uid. Otherwise, an unprivileged user could modify the contents of the chroot
+// and so subvert the process running inside it.
+func CheckChrootSafety(path string, uid int) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("chroot path %q is not a directory", path)
+	}
+
+	mode := fi.Mode().Perm()
+	if mode&0o002 != 0 {
+		return fmt.Errorf("chroot directory %q is world-writable (mode %04o)", path, mode)
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of chroot directory %q", path)
+	}
+
+	if mode&0o020 != 0 && st.Gid != 0 {
+		return fmt.Errorf("chroot directory %q is group-writable by non-root group %d (mode %04o)", path, st.Gid, mode)
+	}
+
+	if st.Uid != 0 && int64(st.Uid) != int64(uid) {
+		return fmt.Errorf("chroot directory %q is owned by UID %d, not by root or the service user", path, st.Uid)
+	}
+
+	return nil
+}
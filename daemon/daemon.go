@@ -1,3 +1,4 @@
+//go:build !windows
 // +build !windows
 
 // Package daemon provides functions to assist with the writing of UNIX-style
@@ -5,15 +6,22 @@
 package daemon
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/hlandau/svcutils.v1/dupfd"
 	"gopkg.in/hlandau/svcutils.v1/exepath"
+	"io"
 	"os"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 // Initialises a daemon with recommended values. Called by Daemonize.
 //
-// Currently, this only calls umask(0) and chdir("/").
+// Currently, this calls umask(0) and chdir("/") and disables core dumps by
+// setting the soft RLIMIT_CORE limit to zero. The hard limit is left
+// unchanged, so core dumps can be reenabled using SetCoreDumpSize.
 func Init() error {
 	syscall.Umask(0)
 
@@ -22,40 +30,214 @@ func Init() error {
 		return err
 	}
 
-	// setrlimit RLIMIT_CORE
-	return nil
+	_, max, err := getrlimit(syscall.RLIMIT_CORE)
+	if err != nil {
+		return err
+	}
+
+	return setrlimit(syscall.RLIMIT_CORE, 0, max)
+}
+
+// Set to "1" in the environment of a child started by Fork.
+const forkedEnv = "_SERVICE_FORKED"
+
+// The environment variable used to tell a child started by Fork the file
+// descriptor on which it receives the write end of the pipe used to report the
+// result of its initialisation. The pipe follows any ForkOptions.ExtraFiles.
+const forkPipeEnv = "_DAEMON_FORK_PIPE_FD"
+
+// The environment variables used by Fork to communicate with the child. They
+// must be preserved if the environment is cleared before Fork is called in the
+// child. They are removed from the environment when Fork is called.
+var ForkEnvVars = []string{forkedEnv, forkPipeEnv}
+
+// In a child started by Fork, the write end of the status pipe. Set to nil once
+// the result has been reported.
+var forkPipe *os.File
+
+// Set in a child started by Fork once Fork has been called.
+var forked bool
+
+// Returns true if this process is a child started by Fork. This can be called
+// before the child calls Fork.
+func IsForkedChild() bool {
+	return forked || os.Getenv(forkedEnv) == "1"
+}
+
+// Options for Fork.
+type ForkOptions struct {
+	// Files to be passed to the child in addition to stdin, stdout and stderr.
+	// The child receives them as file descriptors 3 onwards, in order.
+	ExtraFiles []*os.File
+
+	// If the child has not reported a result within this time, the parent
+	// kills the child and Fork returns an error. If zero, the parent waits
+	// indefinitely.
+	Timeout time.Duration
+
+	// Optional system-specific attributes with which the child is started. For
+	// example, on Linux, this can be used to start the child in new
+	// namespaces.
+	Sys *syscall.SysProcAttr
+
+	// The binary to execute as the child. If empty, the current binary
+	// (exepath.Abs) is used. This allows, for example, a test to substitute a
+	// helper binary.
+	ExePath string
 }
 
-const forkedArg = "$*_FORKED_*$"
+// Psuedo-forks by re-executing the current binary with an environment variable
+// telling it not to re-execute itself again. Returns true in the
+// parent process and false in the child. opts may be nil, in which case
+// default options are used.
+//
+// The parent waits until the child calls ReportForkResult (or exits). If the
+// child reports an error, or exits without reporting a result, Fork returns an
+// error in the parent.
+func Fork(opts *ForkOptions) (isParent bool, err error) {
+	if opts == nil {
+		opts = &ForkOptions{}
+	}
+
+	if os.Getenv(forkedEnv) == "1" {
+		fd, err := strconv.Atoi(os.Getenv(forkPipeEnv))
+		if err != nil || fd <= 2 {
+			return false, fmt.Errorf("invalid fork status pipe: %q", os.Getenv(forkPipeEnv))
+		}
 
-// Psuedo-forks by re-executing the current binary with a special command line
-// argument telling it not to re-execute itself again. Returns true in the
-// parent process and false in the child.
-func Fork() (isParent bool, err error) {
-	if os.Args[len(os.Args)-1] == forkedArg {
-		os.Args = os.Args[0 : len(os.Args)-1]
+		os.Unsetenv(forkedEnv)
+		os.Unsetenv(forkPipeEnv)
+		forked = true
+		syscall.CloseOnExec(fd)
+		forkPipe = os.NewFile(uintptr(fd), "forkpipe")
 		return false, nil
 	}
 
+	r, w, err := os.Pipe()
+	if err != nil {
+		return true, err
+	}
+	defer r.Close()
+
+	exe := opts.ExePath
+	if exe == "" {
+		exe = exepath.Abs
+	}
+
 	newArgs := make([]string, 0, len(os.Args))
-	newArgs = append(newArgs, exepath.Abs)
+	newArgs = append(newArgs, exe)
 	newArgs = append(newArgs, os.Args[1:]...)
-	newArgs = append(newArgs, forkedArg)
 
 	// Start the child process.
 	//
 	// Pass along the standard FD for now - we'll remap them to /dev/null
 	// in due time. This ensures anything expecting these to exist isn't confused,
 	// and allows pre-daemonization failures to at least get output to somewhere.
-	proc, err := os.StartProcess(exepath.Abs, newArgs, &os.ProcAttr{
-		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	files = append(files, opts.ExtraFiles...)
+	files = append(files, w)
+	env := append(os.Environ(), forkedEnv+"=1", forkPipeEnv+"="+strconv.Itoa(len(files)-1))
+
+	proc, err := os.StartProcess(exe, newArgs, &os.ProcAttr{
+		Files: files,
+		Env:   env,
+		Sys:   opts.Sys,
 	})
+	// Close our copy of the write end so that we see EOF when the child closes
+	// its copy.
+	w.Close()
 	if err != nil {
 		return true, err
 	}
 
-	proc.Release()
-	return true, nil
+	return true, waitForChild(proc, r, opts.Timeout)
+}
+
+// Like Fork, with the given timeout. See ForkOptions.Timeout.
+func ForkWithTimeout(timeout time.Duration) (isParent bool, err error) {
+	return Fork(&ForkOptions{Timeout: timeout})
+}
+
+// Like Fork, with the given timeout and system-specific attributes. See
+// ForkOptions.
+func ForkWithAttr(timeout time.Duration, sys *syscall.SysProcAttr) (isParent bool, err error) {
+	return Fork(&ForkOptions{Timeout: timeout, Sys: sys})
+}
+
+// The first byte written to the status pipe by ReportForkResult. An error is
+// followed by its message.
+const (
+	forkStatusOK    = 0
+	forkStatusError = 1
+)
+
+// Waits for the child to report its initialisation result on r.
+func waitForChild(proc *os.Process, r *os.File, timeout time.Duration) error {
+	defer proc.Release()
+
+	if timeout > 0 {
+		err := r.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	msg, err := io.ReadAll(r)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		proc.Kill()
+		return fmt.Errorf("child did not start within %v", timeout)
+	} else if err != nil {
+		return err
+	}
+
+	if len(msg) == 0 {
+		// The pipe was closed without a result being reported, so the child
+		// has died or is about to.
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(proc.Pid, &ws, 0, nil)
+		switch {
+		case err != nil:
+			return fmt.Errorf("child exited before starting: %v", err)
+		case ws.Exited():
+			return fmt.Errorf("child exited with status %d before starting", ws.ExitStatus())
+		case ws.Signaled():
+			return fmt.Errorf("child killed by signal %v before starting", ws.Signal())
+		default:
+			return fmt.Errorf("child exited before starting")
+		}
+	}
+
+	if msg[0] != forkStatusOK {
+		return fmt.Errorf("child failed to start: %s", msg[1:])
+	}
+
+	return nil
+}
+
+// In a process started by Fork, reports the result of its initialisation to
+// the parent, which is waiting in Fork. If err is nil, Fork returns
+// successfully in the parent; otherwise, it returns an error containing the
+// text of err.
+//
+// Only the first call has any effect. Does nothing in a process which was not
+// started by Fork.
+func ReportForkResult(err error) {
+	if forkPipe == nil {
+		return
+	}
+
+	if err != nil {
+		msg := err.Error()
+		if msg == "" {
+			msg = "unknown error"
+		}
+		forkPipe.Write(append([]byte{forkStatusError}, msg...))
+	} else {
+		forkPipe.Write([]byte{forkStatusOK})
+	}
+
+	forkPipe.Close()
+	forkPipe = nil
 }
 
 var haveStderr = true
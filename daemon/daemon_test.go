@@ -0,0 +1,153 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Set in the environment of a child started by a test to make it report an
+// error to the parent.
+const testChildErrEnv = "_DAEMON_TEST_CHILD_ERR"
+
+// Set in the environment of a child started by a test to make it exit with
+// the given status without reporting a result.
+const testChildExitEnv = "_DAEMON_TEST_CHILD_EXIT"
+
+func TestMain(m *testing.M) {
+	// When started by Fork in one of the tests below, behave as the child.
+	if IsForkedChild() {
+		isParent, err := Fork(nil)
+		if isParent || err != nil {
+			os.Exit(2)
+		}
+
+		if msg := os.Getenv(testChildErrEnv); msg != "" {
+			ReportForkResult(errors.New(msg))
+			os.Exit(1)
+		}
+
+		if s := os.Getenv(testChildExitEnv); s != "" {
+			n, _ := strconv.Atoi(s)
+			os.Exit(n)
+		}
+
+		ReportForkResult(nil)
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func forkTestChild(t *testing.T) error {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skipf("cannot determine test executable: %v", err)
+	}
+	isParent, err := Fork(&ForkOptions{Timeout: 30 * time.Second, ExePath: exe})
+	if !isParent {
+		t.Fatal("Fork returned in the child")
+	}
+	return err
+}
+
+func TestForkSuccess(t *testing.T) {
+	err := forkTestChild(t)
+	if err != nil {
+		t.Fatalf("Fork: %v", err)
+	}
+}
+
+// A child which exits without reporting a result has failed, even if it exits
+// successfully.
+func TestForkChildExit(t *testing.T) {
+	t.Setenv(testChildExitEnv, "3")
+
+	err := forkTestChild(t)
+	if err == nil || !strings.Contains(err.Error(), "status 3") {
+		t.Fatalf("expected exit status error, got %v", err)
+	}
+}
+
+func TestForkChildError(t *testing.T) {
+	t.Setenv(testChildErrEnv, "test failure")
+
+	err := forkTestChild(t)
+	if err == nil || !strings.Contains(err.Error(), "test failure") {
+		t.Fatalf("expected child error, got %v", err)
+	}
+}
+
+// The old sentinel argument must no longer cause a process to consider itself
+// a forked child.
+func TestForkedArgIgnored(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = append(append([]string(nil), args...), "$*_FORKED_*$")
+
+	if IsForkedChild() {
+		t.Fatal("sentinel argument treated as forked child")
+	}
+}
+
+func TestForkChildClearsEnv(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Fork takes ownership of the descriptor in the child.
+	fd, err := dupFD(w)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(forkedEnv, "1")
+	t.Setenv(forkPipeEnv, fd)
+	defer func() {
+		forked = false
+		forkPipe = nil
+	}()
+
+	if !IsForkedChild() {
+		t.Fatal("IsForkedChild false with environment variable set")
+	}
+
+	isParent, err := Fork(nil)
+	if err != nil || isParent {
+		t.Fatalf("Fork in child: isParent=%v, err=%v", isParent, err)
+	}
+
+	for _, name := range ForkEnvVars {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s not cleared", name)
+		}
+	}
+
+	if !IsForkedChild() {
+		t.Error("IsForkedChild false after Fork in child")
+	}
+
+	ReportForkResult(nil)
+	buf := make([]byte, 2)
+	if n, _ := r.Read(buf); n != 1 || buf[0] != forkStatusOK {
+		t.Errorf("unexpected data on status pipe: %q", buf[:n])
+	}
+}
+
+func dupFD(f *os.File) (string, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(fd), nil
+}
@@ -0,0 +1,109 @@
+//go:build !windows
+// +build !windows
+
+// Package fdpass passes open file descriptors, such as listening sockets,
+// between processes using SCM_RIGHTS messages over UNIX domain sockets.
+package fdpass
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/hlandau/service.v3/internal/peercred"
+)
+
+// Returns the path of the UNIX domain socket, within dir, on which the process
+// with the given PID receives file descriptors. A process wishing to receive
+// file descriptors should listen on this path and call ReceiveFDs on each
+// accepted connection.
+//
+// dir must only be writable by the user the service runs as, for example the
+// directory returned by Manager.RuntimeDir. Otherwise, another user could
+// create the socket first. The credentials of the peer are checked on both
+// sides of the connection, but this would still prevent the file descriptors
+// from being passed.
+func SocketPath(dir string, pid int) string {
+	return filepath.Join(dir, "fdpass-"+strconv.Itoa(pid)+".sock")
+}
+
+// Connects to the socket returned by SocketPath for the given directory and
+// PID and sends fds in a single SCM_RIGHTS message. The receiving process
+// obtains its own descriptors for the files; the caller's files remain open.
+//
+// The file descriptors are only sent if the socket is held by a process
+// running as the same user as the caller and, where the platform reports it,
+// by the process with the given PID.
+func PassFDs(dir string, pid int, fds []*os.File) error {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: SocketPath(dir, pid), Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	peerUID, peerPID, err := peercred.Get(conn)
+	if err != nil {
+		return fmt.Errorf("cannot get credentials of receiving process: %v", err)
+	}
+
+	if peerUID != os.Geteuid() || (peerPID >= 0 && peerPID != pid) {
+		return fmt.Errorf("socket is held by another process (PID %d, UID %d)", peerPID, peerUID)
+	}
+
+	ints := make([]int, len(fds))
+	for i, f := range fds {
+		ints[i] = int(f.Fd())
+	}
+
+	// At least one byte of ordinary data must accompany the control message.
+	_, _, err = conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(ints...), nil)
+	return err
+}
+
+// Receives up to n file descriptors sent on conn by PassFDs. The returned
+// files are owned by the caller. Fails if the sending process does not run as
+// the same user as the caller.
+func ReceiveFDs(conn *net.UnixConn, n int) ([]*os.File, error) {
+	peerUID, _, err := peercred.Get(conn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get credentials of sending process: %v", err)
+	}
+
+	if peerUID != os.Geteuid() {
+		return nil, fmt.Errorf("sending process runs as another user (UID %d)", peerUID)
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(n*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "fdpass-"+strconv.Itoa(fd)))
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file descriptors received")
+	}
+
+	return files, nil
+}
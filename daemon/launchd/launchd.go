@@ -0,0 +1,22 @@
+// Package launchd provides access to sockets created by launchd on behalf of a
+// service.
+package launchd
+
+import (
+	"errors"
+	"net"
+)
+
+// Retrieves the listening sockets which launchd created for the job under the
+// given name (the key of the socket in the Sockets section of the job's
+// property list), by calling launch_activate_socket(3). Only stream sockets
+// are supported.
+//
+// Only supported on macOS, and only when cgo is enabled. Returns
+// ErrNotSupported otherwise.
+func ActivateSocket(name string) ([]net.Listener, error) {
+	return activateSocket(name)
+}
+
+// Returned by ActivateSocket if it is not supported on the current platform.
+var ErrNotSupported = errors.New("launchd socket activation not supported")
@@ -0,0 +1,52 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package launchd
+
+/*
+#include <launch.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func activateSocket(name string) ([]net.Listener, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var fds *C.int
+	var count C.size_t
+	rc := C.launch_activate_socket(cname, &fds, &count)
+	if rc != 0 {
+		return nil, fmt.Errorf("cannot activate launchd socket %q: %v", name, syscall.Errno(rc))
+	}
+	defer C.free(unsafe.Pointer(fds))
+
+	var listeners []net.Listener
+	for i, fd := range unsafe.Slice(fds, int(count)) {
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			// Close the listeners already created and the remaining descriptors.
+			for _, l := range listeners {
+				l.Close()
+			}
+			for _, fd := range unsafe.Slice(fds, int(count))[i+1:] {
+				syscall.Close(int(fd))
+			}
+			return nil, fmt.Errorf("cannot use launchd socket %q: %v", name, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
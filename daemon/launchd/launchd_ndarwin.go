@@ -0,0 +1,10 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package launchd
+
+import "net"
+
+func activateSocket(name string) ([]net.Listener, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+// Locks all current and future pages of the process's memory into RAM by
+// calling mlockall(MCL_CURRENT|MCL_FUTURE), so that they are never swapped to
+// disk. This is useful to prevent secret key material from being written to
+// swap. Generally requires root, CAP_IPC_LOCK or a sufficient RLIMIT_MEMLOCK.
+func LockMemory() error {
+	return lockMemory()
+}
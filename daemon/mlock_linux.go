@@ -0,0 +1,23 @@
+package daemon
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func lockMemory() error {
+	err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE)
+	if err == nil {
+		return nil
+	}
+
+	if err == unix.ENOMEM {
+		var rl unix.Rlimit
+		if unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rl) == nil && rl.Cur != unix.RLIM_INFINITY {
+			return fmt.Errorf("mlockall: %v (RLIMIT_MEMLOCK is %d bytes; try increasing it, e.g. with LimitMEMLOCK=infinity under systemd)", err, rl.Cur)
+		}
+	}
+
+	return fmt.Errorf("mlockall: %v", err)
+}
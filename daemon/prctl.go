@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import "errors"
+
+// Sets the kernel name of the main thread of the process, as visible in
+// /proc/<pid>/comm and tools such as top and ps. This is done regardless of
+// which thread the calling goroutine is running on. Names longer than 15 bytes
+// are truncated. Unlike gsptcall.SetProcTitle, this does not alter the
+// process's argv.
+//
+// Only supported on Linux. Returns ErrNotSupported on other platforms.
+func SetThreadName(name string) error {
+	return setThreadName(name)
+}
+
+// Makes the process a child subreaper, so that orphaned descendants are
+// reparented to it rather than to init. The process must then reap them.
+//
+// Only supported on Linux. Returns ErrNotSupported on other platforms.
+func SetSubreaper() error {
+	return setSubreaper()
+}
+
+// Returned by functions which are not supported on the current platform.
+var ErrNotSupported = errors.New("not supported on this platform")
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const pPR_SET_CHILD_SUBREAPER = 36
+
+// The maximum length of a thread name, excluding the terminating NUL.
+const maxThreadNameLen = 15
+
+func setThreadName(name string) error {
+	if len(name) > maxThreadNameLen {
+		name = name[:maxThreadNameLen]
+	}
+
+	// PR_SET_NAME only affects the calling thread, which for a goroutine may be
+	// any thread, so write the name of the main thread directly.
+	path := "/proc/self/task/" + strconv.Itoa(os.Getpid()) + "/comm"
+	return os.WriteFile(path, []byte(name), 0)
+}
+
+func setSubreaper() error {
+	_, _, e1 := syscall.Syscall6(syscall.SYS_PRCTL, pPR_SET_CHILD_SUBREAPER, 1, 0, 0, 0, 0)
+	if e1 != 0 {
+		return e1
+	}
+
+	return nil
+}
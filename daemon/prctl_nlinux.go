@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package daemon
+
+func setThreadName(name string) error {
+	return ErrNotSupported
+}
+
+func setSubreaper() error {
+	return ErrNotSupported
+}
@@ -0,0 +1,27 @@
+// Package privatetmp gives a process private temporary directories by mounting
+// fresh tmpfs filesystems over /tmp and /var/tmp within its own mount
+// namespace.
+package privatetmp
+
+import "errors"
+
+// The directories over which a tmpfs is mounted by Setup.
+var Dirs = []string{"/tmp", "/var/tmp"}
+
+// Returned by Setup if the process is not in a private mount namespace, or
+// private temporary directories are not supported on the current platform.
+// Callers which treat private temporary directories as optional can ignore
+// this error.
+var ErrPrivateTmpNotAvailable = errors.New("private /tmp not available")
+
+// Mounts a fresh tmpfs over each of Dirs. The process must already be in its
+// own mount namespace, for example as created by unshare(CLONE_NEWNS), so
+// that the mounts are not visible to other processes; otherwise,
+// ErrPrivateTmpNotAvailable is returned and nothing is mounted. Requires
+// CAP_SYS_ADMIN within the mount namespace.
+//
+// Only supported on Linux. Returns ErrPrivateTmpNotAvailable on other
+// platforms.
+func Setup() error {
+	return setup()
+}
@@ -0,0 +1,51 @@
+package privatetmp
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func setup() error {
+	private, err := inPrivateMountNamespace()
+	if err != nil {
+		return err
+	}
+	if !private {
+		return ErrPrivateTmpNotAvailable
+	}
+
+	// Ensure that mounts made here do not propagate back to the parent
+	// namespace, which may be the case if / is a shared mount.
+	err = unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("cannot make mounts private: %v", err)
+	}
+
+	for _, dir := range Dirs {
+		err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+		if err != nil {
+			return fmt.Errorf("cannot mount tmpfs on %s: %v", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the process is in a different mount namespace to init.
+func inPrivateMountNamespace() (bool, error) {
+	self, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return false, err
+	}
+
+	init, err := os.Readlink("/proc/1/ns/mnt")
+	if err != nil {
+		// The namespaces cannot be compared, so assume that they are the same,
+		// to be safe.
+		return false, nil
+	}
+
+	return self != init, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package privatetmp
+
+func setup() error {
+	return ErrPrivateTmpNotAvailable
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Sets both the soft and hard limits on the number of open files to n. Raising
+// the hard limit generally requires root.
+func SetMaxOpenFiles(n uint64) error {
+	return setLimit("open file", syscall.RLIMIT_NOFILE, n)
+}
+
+// Sets the maximum size of core dump files (RLIMIT_CORE) in bytes. Zero
+// disables core dumps and -1 means no limit. The hard limit is raised if
+// necessary, which generally requires root.
+func SetCoreDumpSize(n int64) error {
+	size := rlimInfinity
+	if n >= 0 {
+		size = uint64(n)
+	}
+
+	_, max, err := getrlimit(syscall.RLIMIT_CORE)
+	if err != nil {
+		return err
+	}
+
+	if size > max {
+		max = size
+	}
+
+	err = setrlimit(syscall.RLIMIT_CORE, size, max)
+	if err != nil {
+		return fmt.Errorf("cannot set core dump size limit: %v", err)
+	}
+
+	return nil
+}
+
+func setLimit(what string, resource int, n uint64) error {
+	err := setrlimit(resource, n, n)
+	if err == nil {
+		return nil
+	}
+
+	_, max, err2 := getrlimit(resource)
+	if err2 == nil && n > max {
+		return fmt.Errorf("cannot set %s limit to %d: exceeds hard limit of %d: %v", what, n, max, err)
+	}
+
+	return fmt.Errorf("cannot set %s limit to %d: %v", what, n, err)
+}
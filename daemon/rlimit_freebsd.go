@@ -0,0 +1,15 @@
+package daemon
+
+import "syscall"
+
+const rlimInfinity uint64 = syscall.RLIM_INFINITY
+
+func setrlimit(resource int, cur, max uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: int64(cur), Max: int64(max)})
+}
+
+func getrlimit(resource int) (cur, max uint64, err error) {
+	var rl syscall.Rlimit
+	err = syscall.Getrlimit(resource, &rl)
+	return uint64(rl.Cur), uint64(rl.Max), err
+}
@@ -0,0 +1,23 @@
+//go:build !freebsd && !windows
+// +build !freebsd,!windows
+
+package daemon
+
+import "syscall"
+
+// RLIM_INFINITY is -1 on some platforms, so it cannot be converted to uint64
+// as a constant.
+var rlimInfinity = func() uint64 {
+	v := int64(syscall.RLIM_INFINITY)
+	return uint64(v)
+}()
+
+func setrlimit(resource int, cur, max uint64) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: cur, Max: max})
+}
+
+func getrlimit(resource int) (cur, max uint64, err error) {
+	var rl syscall.Rlimit
+	err = syscall.Getrlimit(resource, &rl)
+	return rl.Cur, rl.Max, err
+}
@@ -0,0 +1,27 @@
+// Package selinux allows a process to transition to an SELinux context.
+package selinux
+
+import "errors"
+
+// Returned if SELinux is not enabled or not supported on the current
+// platform.
+var ErrNotSupported = errors.New("SELinux not supported")
+
+// Returns true if SELinux is enabled on the system.
+func Enabled() bool {
+	return enabled()
+}
+
+// Sets the context to which the calling thread transitions when it next calls
+// execve(2), as setexeccon(3) does. As with an in-process transition using
+// setcon(3), only the calling thread would be affected, and a Go process has
+// many; transitioning on exec instead confines the whole new process image.
+//
+// The caller must therefore lock the goroutine to its OS thread with
+// runtime.LockOSThread before calling SetExecContext and keep it locked until
+// it execs.
+//
+// Only supported on Linux. Returns ErrNotSupported if SELinux is not enabled.
+func SetExecContext(context string) error {
+	return setExecContext(context)
+}
@@ -0,0 +1,33 @@
+package selinux
+
+import (
+	"fmt"
+	"os"
+)
+
+// The mount point of selinuxfs, which is present if SELinux is enabled.
+const selinuxfs = "/sys/fs/selinux"
+
+func enabled() bool {
+	_, err := os.Stat(selinuxfs + "/enforce")
+	return err == nil
+}
+
+func setExecContext(context string) error {
+	if !enabled() {
+		return ErrNotSupported
+	}
+
+	f, err := os.OpenFile("/proc/thread-self/attr/exec", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(context))
+	if err != nil {
+		return fmt.Errorf("cannot set SELinux context %q: %v", context, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package selinux
+
+func enabled() bool {
+	return false
+}
+
+func setExecContext(context string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,18 @@
+// Package unveil provides functions to restrict the filesystem paths visible
+// to a process.
+package unveil
+
+// On OpenBSD, calls unveil(2) to make path visible to the process with the
+// given permissions, which are a combination of the characters "r", "w", "x"
+// and "c". Once Unveil has been called, only unveiled paths are visible. On
+// other platforms, does nothing.
+func Unveil(path, permissions string) error {
+	return unveil(path, permissions)
+}
+
+// On OpenBSD, calls unveil(NULL, NULL) to prevent any further calls to Unveil
+// from succeeding, finalising the set of visible paths. On other platforms,
+// does nothing.
+func UnveilBlock() error {
+	return unveilBlock()
+}
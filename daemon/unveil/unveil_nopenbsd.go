@@ -0,0 +1,12 @@
+//go:build !openbsd
+// +build !openbsd
+
+package unveil
+
+func unveil(path, permissions string) error {
+	return nil
+}
+
+func unveilBlock() error {
+	return nil
+}
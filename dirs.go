@@ -0,0 +1,37 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+func (h *ihandler) RuntimeDir() string {
+	return h.info.pathInChroot(h.info.runtimeDir)
+}
+
+func (h *ihandler) StateDir() string {
+	return h.info.pathInChroot(h.info.stateDir)
+}
+
+func (h *ihandler) CacheDir() string {
+	return h.info.pathInChroot(h.info.cacheDir)
+}
+
+func (h *ihandler) LogDir() string {
+	return h.info.pathInChroot(h.info.logDir)
+}
+
+// Returns path as seen from inside the chroot, if the service has been
+// chrooted and path lies within it. Otherwise returns path unchanged.
+func (info *Info) pathInChroot(path string) string {
+	if path == "" || info.chrootPath == "" || info.chrootPath == "/" {
+		return path
+	}
+
+	rel, err := filepath.Rel(info.chrootPath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return path
+	}
+
+	return filepath.Join("/", rel)
+}
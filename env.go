@@ -0,0 +1,109 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Linux: Set in the environment once the namespaces in Config.Namespaces have
+// been entered.
+const namespacesEnv = "_SERVICE_NAMESPACES"
+
+// Linux: Set in the environment once the namespaces in
+// Config.UnshareNamespaces have been unshared.
+const unsharedEnv = "_SERVICE_UNSHARED"
+
+// Linux: Set in the environment once the process has transitioned to
+// Config.AppArmorProfile.
+const appArmorEnv = "_SERVICE_APPARMOR"
+
+// Linux: Set in the environment once the process has transitioned to
+// Config.SELinuxContext.
+const selinuxEnv = "_SERVICE_SELINUX"
+
+// UNIX: Set in the environment of the helper process which calls
+// Info.ChrootTeardown to the path of the chroot directory.
+const chrootTeardownEnv = "_SERVICE_CHROOT_TEARDOWN"
+
+// UNIX: Set in the environment of a child started by daemon.Fork to the file
+// descriptor of the PID file opened by the parent when Config.PIDFileParent is
+// set.
+const pidFileFDEnv = "_SERVICE_PIDFILE_FD"
+
+// Environment variables used to communicate with service managers and
+// between processes of the service, which are kept when ClearEnv is set.
+var serviceEnvVars = []string{
+	"NOTIFY_SOCKET", "LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES",
+	"S6_NOTIFY_FD", "XPC_SERVICE_NAME",
+	restartedEnv, restoreFDEnv, inheritedFDsEnv, pidFileFDEnv,
+}
+
+// Markers set in the environment when the service binary re-executes itself.
+var reexecMarkers = []string{namespacesEnv, unsharedEnv, appArmorEnv, selinuxEnv}
+
+// The markers found by takeReexecMarkers.
+var seenMarkers = map[string]bool{}
+
+// Records any markers set by the process which re-executed the service binary
+// and removes them from the environment, so that they are not inherited by
+// other processes the service starts.
+func takeReexecMarkers() {
+	for _, name := range reexecMarkers {
+		if os.Getenv(name) != "" {
+			seenMarkers[name] = true
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// Returns true if this process was started by the service binary
+// re-executing itself.
+func isReexeced() bool {
+	return len(seenMarkers) > 0
+}
+
+// Applies Config.ClearEnv, Config.SetEnv and Config.PrependPath to the
+// environment. This is idempotent, as the service binary may be re-executed
+// with the resulting environment, for example when forking.
+func (info *Info) prepareEnv() {
+	info.clearEnv()
+
+	for k, v := range info.Config.SetEnv {
+		os.Setenv(k, v)
+	}
+
+	if len(info.Config.PrependPath) > 0 {
+		sep := string(filepath.ListSeparator)
+		prefix := strings.Join(info.Config.PrependPath, sep)
+		old := os.Getenv("PATH")
+		switch {
+		case old == "":
+			os.Setenv("PATH", prefix)
+		case old != prefix && !strings.HasPrefix(old, prefix+sep):
+			os.Setenv("PATH", prefix+sep+old)
+		}
+	}
+}
+
+// If Config.ClearEnv is set, removes all environment variables other than
+// those listed in Config.KeepEnvVars and those needed for operation.
+func (info *Info) clearEnv() {
+	if !info.Config.ClearEnv {
+		return
+	}
+
+	keep := map[string]string{}
+	for _, names := range [][]string{keptEnvVars, serviceEnvVars, info.Config.KeepEnvVars} {
+		for _, name := range names {
+			if v, ok := os.LookupEnv(name); ok {
+				keep[name] = v
+			}
+		}
+	}
+
+	os.Clearenv()
+	for k, v := range keep {
+		os.Setenv(k, v)
+	}
+}
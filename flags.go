@@ -0,0 +1,300 @@
+package service
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Registers flags on fs which set the fields of cfg which are applicable to
+// the current platform. Flag names are derived from field names, e.g. UID
+// becomes -uid and PIDFile becomes -pid-file, and the "help" annotations of
+// the fields are used as usage strings. The current values of the fields are
+// used as defaults. Fields set by flags are recorded in cfg.ExplicitlySet.
+//
+// Only fields with simple types are supported: strings, booleans, integers,
+// durations, string slices (set by repeating the flag) and string maps (set
+// by repeating the flag with values of the form key=value).
+//
+// This package does not depend on github.com/spf13/pflag or cobra. To use them,
+// bind to a flag.FlagSet and add it to a pflag.FlagSet, such as that returned
+// by a cobra.Command's PersistentFlags method, with its AddGoFlagSet method.
+func BindFlags(cfg *Config, fs *flag.FlagSet) {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range settableConfigFields() {
+		cv := &configValue{v: v.FieldByIndex(f.Index), cfg: cfg, field: f.Name}
+		fs.Var(cv, flagName(f.Name), f.Tag.Get("help"))
+	}
+}
+
+// Sets the fields of cfg which are applicable to the current platform from
+// environment variables named after the fields in upper case with words
+// separated by underscores, prefixed with prefix and an underscore; for
+// example, with prefix "FOO", UID is set from FOO_UID and PIDFile from
+// FOO_PID_FILE. Fields whose variables are not set are left unchanged.
+//
+// The same field types are supported as for BindFlags. Booleans may be given
+// as "true", "1" or "yes" (or "false", "0" or "no"). String slices and maps are
+// given as comma-separated lists, the elements of maps being of the form
+// key=value. Fields set are recorded in cfg.ExplicitlySet.
+func PopulateFromEnv(cfg *Config, prefix string) error {
+	if prefix != "" {
+		prefix += "_"
+	}
+
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range settableConfigFields() {
+		name := prefix + strings.ToUpper(splitFieldName(f.Name, '_'))
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		fv := v.FieldByIndex(f.Index)
+		cv := &configValue{v: fv, cfg: cfg, field: f.Name}
+		switch fv.Kind() {
+		case reflect.Slice, reflect.Map:
+			fv.Set(reflect.Zero(fv.Type()))
+			for _, item := range strings.Split(s, ",") {
+				if item == "" {
+					continue
+				}
+				err := cv.Set(item)
+				if err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+			}
+		default:
+			err := cv.Set(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Fields of Config which only affect a single invocation of the service
+// binary, such as the one which installs it, and which Args omits.
+var invocationFields = map[string]bool{
+	"Command":      true,
+	"AutoInstall":  true,
+	"ForceInstall": true,
+	"DryRun":       true,
+	"JSON":         true,
+}
+
+// Returns command line arguments, in the form accepted by the flags
+// registered by BindFlags, which set each field of cfg which is non-zero or
+// listed in cfg.ExplicitlySet to its current value, so that fields set in code
+// are included as well as those set by flags. Fields which only affect a
+// single invocation, such as Command, are omitted, as are the fields named in
+// omit.
+//
+// Service file generators use this so that the installed service runs with
+// the settings it was installed with.
+func (cfg *Config) Args(omit ...string) []string {
+	omitted := map[string]bool{}
+	for _, name := range omit {
+		omitted[name] = true
+	}
+
+	var args []string
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range settableConfigFields() {
+		fv := v.FieldByIndex(f.Index)
+		if (fv.IsZero() && !cfg.ExplicitlySet[f.Name]) || invocationFields[f.Name] || omitted[f.Name] {
+			continue
+		}
+
+		prefix := "-" + flagName(f.Name) + "="
+		switch fv.Kind() {
+		case reflect.Slice:
+			for i := 0; i < fv.Len(); i++ {
+				args = append(args, prefix+fv.Index(i).String())
+			}
+		case reflect.Map:
+			var keys []string
+			for _, k := range fv.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				args = append(args, prefix+k+"="+fv.MapIndex(reflect.ValueOf(k)).String())
+			}
+		default:
+			args = append(args, prefix+fmt.Sprint(fv.Interface()))
+		}
+	}
+
+	return args
+}
+
+// Returns the fields of Config which are applicable to the current platform
+// and have a type which configValue can set.
+func settableConfigFields() []reflect.StructField {
+	var fields []reflect.StructField
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.IsExported() && UsingPlatform(f.Tag.Get("platform")) && isSettableType(f.Type) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func isSettableType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// Converts a field name to a flag name, e.g. "PIDFile" to "pid-file".
+func flagName(fieldName string) string {
+	return splitFieldName(fieldName, '-')
+}
+
+// Converts a field name to lower case words separated by sep, treating runs
+// of capitals as acronyms, e.g. "CPUProfile" becomes "cpu-profile".
+func splitFieldName(fieldName string, sep rune) string {
+	rs := []rune(fieldName)
+	var b strings.Builder
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+			b.WriteRune(sep)
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// A flag.Value which sets a field of Config and records that it has been
+// set.
+type configValue struct {
+	v     reflect.Value
+	cfg   *Config
+	field string
+}
+
+func (cv *configValue) String() string {
+	// Zero values are reported as "" so that they are not shown as defaults.
+	if cv == nil || !cv.v.IsValid() || cv.v.IsZero() {
+		return ""
+	}
+
+	switch cv.v.Kind() {
+	case reflect.Slice:
+		var parts []string
+		for i := 0; i < cv.v.Len(); i++ {
+			parts = append(parts, cv.v.Index(i).String())
+		}
+		return strings.Join(parts, ",")
+	case reflect.Map:
+		var parts []string
+		iter := cv.v.MapRange()
+		for iter.Next() {
+			parts = append(parts, iter.Key().String()+"="+iter.Value().String())
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(cv.v.Interface())
+	}
+}
+
+func (cv *configValue) IsBoolFlag() bool {
+	return cv.v.Kind() == reflect.Bool
+}
+
+func (cv *configValue) Set(s string) error {
+	err := cv.set(s)
+	if err != nil {
+		return err
+	}
+
+	cv.cfg.markSet(cv.field)
+	return nil
+}
+
+func (cv *configValue) set(s string) error {
+	v := cv.v
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+
+	case v.Kind() == reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+
+	case v.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+
+	case v.Kind() == reflect.Slice:
+		v.Set(reflect.Append(v, reflect.ValueOf(s).Convert(v.Type().Elem())))
+
+	case v.Kind() == reflect.Map:
+		k, val, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value: %q", s)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(val))
+
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+
+	return nil
+}
+
+// Like strconv.ParseBool, but also accepts "yes", "no", "on" and "off".
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	default:
+		return strconv.ParseBool(s)
+	}
+}
@@ -1,3 +1,3 @@
 module gopkg.in/hlandau/service.v3
 
-go 1.19
+go 1.21
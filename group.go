@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+)
+
+// Returned by Manager.Wait if a goroutine started by Manager.Go panicked, and
+// by a service whose RunFunc panicked if Info.RecoverPanic is set.
+type PanicError struct {
+	Value interface{} // The value passed to panic.
+	Stack []byte      // The stack trace of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Formats the error. The %+v verb also prints the stack trace.
+func (e *PanicError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		fmt.Fprintf(s, "%s\n\n%s", e.Error(), e.Stack)
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	default:
+		io.WriteString(s, e.Error())
+	}
+}
+
+// Tracks the goroutines started by Manager.Go.
+type goroutineGroup struct {
+	initOnce sync.Once
+	ctx      context.Context
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	waiting  bool
+	err      error
+}
+
+// Starts f in a new goroutine with a context which is cancelled when stopChan
+// is closed.
+func (g *goroutineGroup) Go(stopChan <-chan struct{}, f func(ctx context.Context)) error {
+	g.initOnce.Do(func() {
+		var cancel context.CancelFunc
+		g.ctx, cancel = context.WithCancel(context.Background())
+		go func() {
+			<-stopChan
+			cancel()
+		}()
+	})
+
+	// wg.Add must not race with wg.Wait, so goroutines cannot be started once
+	// Wait has been called.
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.waiting {
+		return fmt.Errorf("cannot start goroutine after Wait has been called")
+	}
+
+	if g.ctx.Err() != nil {
+		return fmt.Errorf("service is stopping")
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.setErr(&PanicError{Value: r, Stack: debug.Stack()})
+			}
+		}()
+
+		f(g.ctx)
+	}()
+
+	return nil
+}
+
+func (g *goroutineGroup) setErr(err error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// Waits for all goroutines started by Go to return, and returns the error for
+// the first goroutine to panic, if any.
+func (g *goroutineGroup) Wait() error {
+	g.mutex.Lock()
+	g.waiting = true
+	g.mutex.Unlock()
+
+	g.wg.Wait()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.err
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// The number of consecutive health check failures after which
+// Config.HealthCheckFailureAction is taken.
+const healthCheckMaxFailures = 3
+
+// Prefix of the status reported while the health check is failing.
+const healthCheckFailedStatus = "HEALTH_CHECK_FAILED: "
+
+func checkHealthCheckFailureAction(action string) error {
+	switch action {
+	case "", "log", "stop", "restart":
+		return nil
+	default:
+		return fmt.Errorf("unknown health check failure action: %q", action)
+	}
+}
+
+// Returns a channel on which the results of periodic health checks are sent,
+// or nil if health checking is not configured. Checking ceases when doneChan
+// is closed.
+func (info *Info) startHealthChecks(doneChan <-chan struct{}) <-chan error {
+	if info.HealthCheck == nil || info.Config.HealthCheckInterval <= 0 {
+		return nil
+	}
+
+	resultChan := make(chan error)
+	go func() {
+		ticker := time.NewTicker(info.Config.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-doneChan:
+				return
+			}
+
+			err := info.HealthCheck()
+			select {
+			case resultChan <- err:
+			case <-doneChan:
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+// Handles the result of a health check.
+func (h *ihandler) healthCheckResult(err error) {
+	info := h.info
+	if err == nil {
+		if h.healthErr != nil {
+			info.logger().Info("health check succeeded", "service", info.Name)
+			h.healthErr = nil
+			h.healthFailures = 0
+			h.updateStatus()
+		}
+		return
+	}
+
+	info.logError("Health check failed", err)
+	h.healthErr = err
+	h.healthFailures++
+	h.updateStatus()
+
+	if h.healthFailures < healthCheckMaxFailures || h.stopping {
+		return
+	}
+
+	switch info.Config.HealthCheckFailureAction {
+	case "stop":
+		h.stop()
+	case "restart":
+		err := h.Restart()
+		if err != nil {
+			info.logError("Cannot restart after health check failure", err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+// Returns the name of the init system or service manager which appears to be
+// managing the current process or, failing that, the system. The result is
+// one of "systemd", "upstart", "openrc", "launchd", "sysvinit", "runit",
+// "s6", "supervisord", "windows" or "unknown". The names match those used with
+// RegisterGenerator, so the result can be used to select a generator.
+//
+// Supervisors which can run on top of another init system (runit, s6 and
+// supervisord) are only reported when they are the parent of the current
+// process or appear to be the system init.
+//
+// Detection is heuristic and is based on environment variables, the parent
+// process and the presence of files which the various init systems create at
+// runtime.
+func DetectedInitSystem() string {
+	return detectInitSystem()
+}
+
+// Returns true if the process appears to be running in a container, such as
+// one run by Docker, Podman, LXC, systemd-nspawn or Kubernetes. Always false
+// on Windows.
+//
+// In a container, the service does not fork, daemonize or write a PID file,
+// as for Config.SupervisedMode, unless Config.ForceDaemon is set. Detection is
+// heuristic and is based on files and environment variables set by container
+// runtimes and on the cgroup of the init process.
+func IsContainer() bool {
+	return isContainer()
+}
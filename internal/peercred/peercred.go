@@ -0,0 +1,8 @@
+// Package peercred reports the credentials of the process at the other end of
+// a UNIX domain socket connection.
+package peercred
+
+import "errors"
+
+// Returned by Get on platforms where peer credentials cannot be determined.
+var ErrNotSupported = errors.New("peer credentials not supported on this platform")
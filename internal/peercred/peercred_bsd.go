@@ -0,0 +1,33 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package peercred
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the UID of the process at the other end of conn. The PID is not
+// reported, so -1 is returned for it.
+func Get(conn *net.UnixConn) (uid, pid int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return int(cred.Uid), -1, nil
+}
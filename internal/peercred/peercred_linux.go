@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package peercred
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the UID and PID of the process at the other end of conn.
+func Get(conn *net.UnixConn) (uid, pid int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, -1, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return int(cred.Uid), int(cred.Pid), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package peercred
+
+import "net"
+
+// Returns ErrNotSupported.
+func Get(conn *net.UnixConn) (uid, pid int, err error) {
+	return -1, -1, ErrNotSupported
+}
@@ -0,0 +1,70 @@
+// Package svcfile contains helpers shared by the packages which generate
+// service files for the various init systems.
+package svcfile
+
+import (
+	"strings"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/svcutils.v1/exepath"
+)
+
+// Returns the user and group, if any, which the service file should start the
+// service as. These are Config.UID and Config.GID, unless the service must be
+// started as root so that it can chroot into Config.Chroot before dropping
+// privileges itself, in which case both are empty.
+func User(info *service.Info) (uid, gid string) {
+	if Chroots(info) {
+		return "", ""
+	}
+
+	return info.Config.UID, info.Config.GID
+}
+
+// Reports whether the service chroots when it drops privileges: Config.UID is
+// set and the chroot directory, Config.Chroot or, if that is empty,
+// Info.DefaultChroot, is not "/".
+func Chroots(info *service.Info) bool {
+	chroot := info.Config.Chroot
+	if chroot == "" {
+		chroot = info.DefaultChroot
+	}
+	return info.Config.UID != "" && chroot != "" && chroot != "/"
+}
+
+// Returns the command line which runs the service described by info with the
+// settings it was installed with: the service binary, followed by
+// info.Config.Args. Config.UID and Config.GID are omitted if the service file
+// starts the service as that user itself; see User.
+func Command(info *service.Info) []string {
+	exe := info.ExePath
+	if exe == "" {
+		exe = exepath.Abs
+	}
+
+	var omit []string
+	if !Chroots(info) {
+		omit = []string{"UID", "GID"}
+	}
+
+	return append([]string{exe}, info.Config.Args(omit...)...)
+}
+
+// Collapses any whitespace, including newlines, in s to single spaces.
+func SingleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Quotes a string for the shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Quotes each word for the shell and joins them with spaces.
+func ShellJoin(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = ShellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,108 @@
+// Package svcfiletest contains the golden file tests shared by the packages
+// which generate service files for the various init systems.
+package svcfiletest
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// A golden file test case.
+type Case struct {
+	// The name of the case, which names its golden files in testdata.
+	Name string
+
+	// The flags the service is installed with.
+	Args []string
+
+	// Optional. Called to make any further changes to the Info.
+	Setup func(info *service.Info)
+}
+
+// For each case, generates a service file using gen and compares it with the
+// golden file testdata/<name><ext>. gen is passed a copy of base with the
+// flags of the case applied. If the -update flag is given, the golden files are
+// rewritten first.
+func GoldenTest(t *testing.T, base service.Info, ext string, cases []Case, gen func(w io.Writer, info *service.Info) error) {
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := gen(&buf, caseInfo(t, base, c))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compare(t, filepath.Join("testdata", c.Name+ext), buf.Bytes())
+		})
+	}
+}
+
+// As for GoldenTest, for generators which write several files to a directory.
+// Each of files is compared with the golden file testdata/<name>/<file>.
+func GoldenDirTest(t *testing.T, base service.Info, files []string, cases []Case, gen func(dir string, info *service.Info) error) {
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), base.Name)
+			err := gen(dir, caseInfo(t, base, c))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, fn := range files {
+				b, err := os.ReadFile(filepath.Join(dir, fn))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				compare(t, filepath.Join("testdata", c.Name, fn), b)
+			}
+		})
+	}
+}
+
+// Returns a copy of base with the flags of c applied.
+func caseInfo(t *testing.T, base service.Info, c Case) *service.Info {
+	info := &base
+
+	fs := flag.NewFlagSet(base.Name, flag.ContinueOnError)
+	service.BindFlags(&info.Config, fs)
+	err := fs.Parse(c.Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Setup != nil {
+		c.Setup(info)
+	}
+
+	return info
+}
+
+func compare(t *testing.T, golden string, b []byte) {
+	if *update {
+		err := os.MkdirAll(filepath.Dir(golden), 0755)
+		if err == nil {
+			err = os.WriteFile(golden, b, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, expected) {
+		t.Errorf("output differs from %s:\n%s", golden, b)
+	}
+}
@@ -0,0 +1,146 @@
+// Package launchd generates launchd property lists for services.
+//
+// Importing this package registers GeneratePlist as the generator used by
+// service.LaunchdBackend, enabling services to be installed under launchd
+// using the "install" service command.
+package launchd
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+func init() {
+	service.RegisterGenerator("launchd", GeneratePlist)
+}
+
+// Writes a launchd property list for the service described by info.
+//
+// The service's Name is used as the job label. The job is started at load and
+// kept alive, with the settings the service was installed with; see
+// service.Config.Args. If Config.UID or Config.GID is set, the job runs as that
+// user or group, unless the service must chroot, in which case it is started
+// as root and drops privileges itself. Any sockets in Config.LaunchdSockets are listed in the Sockets
+// section so that launchd creates them on the service's behalf.
+func GeneratePlist(w io.Writer, info *service.Info) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	bw.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	bw.WriteString(`<plist version="1.0">` + "\n")
+	bw.WriteString("<dict>\n")
+
+	writeString(bw, 1, "Label", info.Name)
+
+	writeKey(bw, 1, "ProgramArguments")
+	indent(bw, 1)
+	bw.WriteString("<array>\n")
+	for _, arg := range svcfile.Command(info) {
+		writeValue(bw, 2, arg)
+	}
+	indent(bw, 1)
+	bw.WriteString("</array>\n")
+
+	writeBool(bw, 1, "RunAtLoad", true)
+	writeBool(bw, 1, "KeepAlive", true)
+
+	uid, gid := svcfile.User(info)
+	if uid != "" {
+		writeString(bw, 1, "UserName", uid)
+	}
+	if gid != "" {
+		writeString(bw, 1, "GroupName", gid)
+	}
+
+	if len(info.Config.LaunchdSockets) > 0 {
+		writeKey(bw, 1, "Sockets")
+		indent(bw, 1)
+		bw.WriteString("<dict>\n")
+		for _, sock := range info.Config.LaunchdSockets {
+			err := writeSocket(bw, 2, sock)
+			if err != nil {
+				return err
+			}
+		}
+		indent(bw, 1)
+		bw.WriteString("</dict>\n")
+	}
+
+	bw.WriteString("</dict>\n")
+	bw.WriteString("</plist>\n")
+	return bw.Flush()
+}
+
+func writeSocket(bw *bufio.Writer, depth int, sock service.LaunchdSocket) error {
+	writeKey(bw, depth, sock.Name)
+	indent(bw, depth)
+	bw.WriteString("<dict>\n")
+
+	switch sock.Network {
+	case "tcp", "udp":
+		host, port, err := net.SplitHostPort(sock.Address)
+		if err != nil {
+			return fmt.Errorf("socket %q: %v", sock.Name, err)
+		}
+		if sock.Network == "udp" {
+			writeString(bw, depth+1, "SockType", "dgram")
+		} else {
+			writeString(bw, depth+1, "SockType", "stream")
+		}
+		if host != "" {
+			writeString(bw, depth+1, "SockNodeName", host)
+		}
+		writeString(bw, depth+1, "SockServiceName", port)
+
+	case "unix":
+		writeString(bw, depth+1, "SockType", "stream")
+		writeString(bw, depth+1, "SockPathName", sock.Address)
+
+	default:
+		return fmt.Errorf("socket %q: unsupported network %q", sock.Name, sock.Network)
+	}
+
+	indent(bw, depth)
+	bw.WriteString("</dict>\n")
+	return nil
+}
+
+func indent(bw *bufio.Writer, depth int) {
+	bw.WriteString(strings.Repeat("\t", depth))
+}
+
+func writeKey(bw *bufio.Writer, depth int, key string) {
+	indent(bw, depth)
+	bw.WriteString("<key>")
+	xml.EscapeText(bw, []byte(key))
+	bw.WriteString("</key>\n")
+}
+
+func writeValue(bw *bufio.Writer, depth int, value string) {
+	indent(bw, depth)
+	bw.WriteString("<string>")
+	xml.EscapeText(bw, []byte(value))
+	bw.WriteString("</string>\n")
+}
+
+func writeString(bw *bufio.Writer, depth int, key, value string) {
+	writeKey(bw, depth, key)
+	writeValue(bw, depth, value)
+}
+
+func writeBool(bw *bufio.Writer, depth int, key string, value bool) {
+	writeKey(bw, depth, key)
+	indent(bw, depth)
+	if value {
+		bw.WriteString("<true/>\n")
+	} else {
+		bw.WriteString("<false/>\n")
+	}
+}
@@ -0,0 +1,27 @@
+package launchd
+
+import (
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile/svcfiletest"
+)
+
+func TestGeneratePlist(t *testing.T) {
+	base := service.Info{
+		Name:    "com.example.service",
+		ExePath: "/usr/local/bin/example",
+	}
+
+	svcfiletest.GoldenTest(t, base, ".plist", []svcfiletest.Case{
+		{Name: "simple"},
+		{Name: "user", Args: []string{"-uid=example", "-gid=staff", "-pid-file=/var/run/example.pid",
+			"-set-env=GREETING=<hello & goodbye>", "-command=install"}},
+		{Name: "chroot", Args: []string{"-uid=example", "-chroot=/var/empty"}, Setup: func(info *service.Info) {
+			info.Config.LaunchdSockets = []service.LaunchdSocket{
+				{Name: "http", Network: "tcp", Address: ":80"},
+				{Name: "control", Network: "unix", Address: "/var/run/example.sock"},
+			}
+		}},
+	}, GeneratePlist)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// An io.Writer which writes each line written to it to an underlying writer,
+// prefixed with a timestamp and a severity level, e.g.
+//
+//	2006-01-02T15:04:05Z ERROR Error in service: ...
+//
+// Partial lines are buffered until they are completed or Flush is called.
+// Suitable for use as Info.ErrorWriter. Safe for concurrent use.
+type ServiceLogWriter struct {
+	w     io.Writer
+	level string
+	mutex sync.Mutex
+	buf   []byte
+}
+
+// Creates a ServiceLogWriter which writes to w, prefixing lines with the
+// given severity level (e.g. "ERROR").
+func NewServiceLogWriter(w io.Writer, level string) *ServiceLogWriter {
+	return &ServiceLogWriter{
+		w:     w,
+		level: level,
+	}
+}
+
+func (lw *ServiceLogWriter) Write(p []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		err := lw.writeLine(lw.buf[:i])
+		lw.buf = lw.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Writes any buffered partial line.
+func (lw *ServiceLogWriter) Flush() error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	if len(lw.buf) == 0 {
+		return nil
+	}
+
+	err := lw.writeLine(lw.buf)
+	lw.buf = nil
+	return err
+}
+
+func (lw *ServiceLogWriter) writeLine(line []byte) error {
+	var b bytes.Buffer
+	b.WriteString(time.Now().UTC().Format(time.RFC3339))
+	b.WriteByte(' ')
+	b.WriteString(lw.level)
+	b.WriteByte(' ')
+	b.Write(line)
+	b.WriteByte('\n')
+	_, err := lw.w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// A command sent to the socket specified by Config.ManagementSocket.
+type ManagementRequest struct {
+	// One of "status", "reload" or "stop".
+	Cmd string `json:"cmd"`
+}
+
+// The response to a ManagementRequest.
+type ManagementResponse struct {
+	// Whether the command succeeded. If not, Error describes why.
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+
+	// The state of the service at the time the command was processed.
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Started  bool   `json:"started"`
+	Ready    bool   `json:"ready"`
+	Stopping bool   `json:"stopping"`
+}
+
+// A request received on the management socket, passed to the main loop of the
+// service so that it is processed there.
+type managementCmd struct {
+	req      ManagementRequest
+	respChan chan<- *ManagementResponse
+}
+
+// The time allowed for a management client to send its request and read the
+// response.
+const managementTimeout = 10 * time.Second
+
+// Opens the socket specified by Config.ManagementSocket and starts accepting
+// connections on it. Requests are sent on cmdChan. Any stale socket left by a
+// previous instance is removed first. The socket is removed when the returned
+// listener is closed. Only root and the service user may connect.
+func (info *Info) listenManagement(cmdChan chan<- managementCmd) (net.Listener, error) {
+	path := info.Config.ManagementSocket
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("management socket %s is in use", path)
+	}
+	os.Remove(path)
+
+	l, err := listenManagementSocket(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open management socket: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go handleManagementConn(conn, cmdChan)
+		}
+	}()
+
+	return l, nil
+}
+
+func handleManagementConn(conn net.Conn, cmdChan chan<- managementCmd) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(managementTimeout))
+
+	err := checkManagementPeer(conn)
+	if err != nil {
+		json.NewEncoder(conn).Encode(&ManagementResponse{Error: fmt.Sprintf("permission denied: %v", err)})
+		return
+	}
+
+	var req ManagementRequest
+	err = json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		json.NewEncoder(conn).Encode(&ManagementResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	respChan := make(chan *ManagementResponse, 1)
+	select {
+	case cmdChan <- managementCmd{req, respChan}:
+	case <-time.After(managementTimeout):
+		return
+	}
+
+	json.NewEncoder(conn).Encode(<-respChan)
+}
+
+// Processes a management command. Called from the main loop of the service.
+func (h *ihandler) managementCommand(req ManagementRequest) *ManagementResponse {
+	var err error
+	switch req.Cmd {
+	case "status":
+	case "reload":
+		if h.info.PluginDir == "" {
+			err = fmt.Errorf("service does not support reloading")
+		} else {
+			err = h.info.loadPlugins(h)
+		}
+	case "stop":
+		if !h.stopping {
+			h.stop()
+		}
+	default:
+		err = fmt.Errorf("unknown command: %q", req.Cmd)
+	}
+
+	resp := &ManagementResponse{
+		OK:       err == nil,
+		Name:     h.info.Name,
+		Status:   h.fullStatus(),
+		Started:  h.started,
+		Ready:    h.IsReady(),
+		Stopping: h.stopping,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	return resp
+}
+
+// Sends a command to the management socket of a running service and returns
+// its response. This is used by the service-ctl tool.
+func SendManagementCommand(path, cmd string) (*ManagementResponse, error) {
+	conn, err := net.DialTimeout("unix", path, managementTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(managementTimeout))
+
+	err = json.NewEncoder(conn).Encode(&ManagementRequest{Cmd: cmd})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp ManagementResponse
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %v", err)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"reflect"
+)
+
+// Returns a Config in which each field is taken from override if it is set
+// there, and from base otherwise. This allows layered configuration, for
+// example defaults overlaid with a configuration file overlaid with command
+// line flags, by merging each layer in turn.
+//
+// A field is set in override if it is non-zero, or if its name is present in
+// override.ExplicitlySet. Thus a field cannot be reset to its zero value (for
+// example, a bool set to true in base cannot be set to false) unless it is
+// listed in ExplicitlySet. ExplicitlySet is populated automatically by
+// BindFlags, PopulateFromEnv and UnmarshalJSON. The ExplicitlySet of the
+// result contains the names in both base and override.
+func MergeConfig(base, override Config) Config {
+	result := base
+	rv := reflect.ValueOf(&result).Elem()
+	ov := reflect.ValueOf(&override).Elem()
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Name == "ExplicitlySet" {
+			continue
+		}
+
+		o := ov.Field(i)
+		if !o.IsZero() || override.ExplicitlySet[f.Name] {
+			rv.Field(i).Set(o)
+		}
+	}
+
+	result.ExplicitlySet = nil
+	for _, m := range []map[string]bool{base.ExplicitlySet, override.ExplicitlySet} {
+		for name, set := range m {
+			if set {
+				result.markSet(name)
+			}
+		}
+	}
+
+	return result
+}
+
+// Records that the named field has been set explicitly.
+func (c *Config) markSet(fieldName string) {
+	if c.ExplicitlySet == nil {
+		c.ExplicitlySet = map[string]bool{}
+	}
+	c.ExplicitlySet[fieldName] = true
+}
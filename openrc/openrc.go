@@ -0,0 +1,94 @@
+// Package openrc generates OpenRC init scripts for services.
+//
+// Importing this package registers GenerateInitScript as the generator used by
+// service.OpenRCBackend, enabling services to be installed under OpenRC using
+// the "install" service command.
+package openrc
+
+import (
+	"bufio"
+	"io"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+func init() {
+	service.RegisterGenerator("openrc", GenerateInitScript)
+}
+
+// Writes an OpenRC init script for the service described by info.
+//
+// The script runs the service in the background using start-stop-daemon, with
+// the settings it was installed with; see service.Config.Args. If
+// Config.PIDFile is set, the service is expected to write it; otherwise,
+// start-stop-daemon writes a PID file under /run. If Config.UID or Config.GID
+// is set, the service is started as that user or group, unless it must chroot,
+// in which case it is started as root and drops privileges itself.
+func GenerateInitScript(w io.Writer, info *service.Info) error {
+	bw := bufio.NewWriter(w)
+
+	description := info.Description
+	if description == "" {
+		description = info.Title
+	}
+
+	pidFile := info.Config.PIDFile
+	makePIDFile := ""
+	if pidFile == "" {
+		pidFile = "/run/" + info.Name + ".pid"
+		makePIDFile = " --make-pidfile"
+	}
+
+	uid, gid := svcfile.User(info)
+	user := uid
+	if gid != "" {
+		user += ":" + gid
+	}
+
+	cmd := svcfile.Command(info)
+
+	bw.WriteString("#!/sbin/openrc-run\n\n")
+	bw.WriteString("name=" + svcfile.ShellQuote(info.Name) + "\n")
+	bw.WriteString("description=" + svcfile.ShellQuote(svcfile.SingleLine(description)) + "\n")
+	bw.WriteString("command=" + svcfile.ShellQuote(cmd[0]) + "\n")
+	bw.WriteString("command_args=" + svcfile.ShellQuote(svcfile.ShellJoin(cmd[1:])) + "\n")
+	bw.WriteString("pidfile=" + svcfile.ShellQuote(pidFile) + "\n")
+	if user != "" {
+		bw.WriteString("command_user=" + svcfile.ShellQuote(user) + "\n")
+	}
+
+	bw.WriteString(`
+depend() {
+	need net
+}
+
+start() {
+	ebegin "Starting ${name}"
+	eval "set -- ${command_args}"
+	start-stop-daemon --start --background` + makePIDFile + ` \
+		--pidfile "${pidfile}" \
+		${command_user:+--user "${command_user}"} \
+		--exec "${command}" -- "$@"
+	eend $?
+}
+
+stop() {
+	ebegin "Stopping ${name}"
+	start-stop-daemon --stop --pidfile "${pidfile}" --exec "${command}"
+	eend $?
+}
+
+status() {
+	if start-stop-daemon --status --pidfile "${pidfile}" --exec "${command}"; then
+		einfo "status: started"
+		return 0
+	else
+		einfo "status: stopped"
+		return 3
+	fi
+}
+`)
+
+	return bw.Flush()
+}
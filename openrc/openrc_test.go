@@ -0,0 +1,23 @@
+package openrc
+
+import (
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile/svcfiletest"
+)
+
+func TestGenerateInitScript(t *testing.T) {
+	base := service.Info{
+		Name:        "example",
+		Description: "An example\nservice.",
+		ExePath:     "/usr/local/bin/example",
+	}
+
+	svcfiletest.GoldenTest(t, base, "", []svcfiletest.Case{
+		{Name: "simple"},
+		{Name: "user", Args: []string{"-uid=example", "-gid=example", "-pid-file=/run/example.pid",
+			"-set-env=GREETING=it's $HOME", "-command=install"}},
+		{Name: "chroot", Args: []string{"-uid=example", "-chroot=/var/empty"}},
+	}, GenerateInitScript)
+}
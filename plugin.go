@@ -0,0 +1,77 @@
+//go:build serviceplugin
+// +build serviceplugin
+
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sort"
+)
+
+// The name of the symbol looked up in each plugin loaded from PluginDir.
+const pluginSymbol = "ServicePlugin"
+
+// Scans PluginDir for plugins which have not yet been loaded, loads them and
+// calls their ServicePlugin function. Errors loading a plugin do not prevent
+// other plugins from being loaded; the first error encountered is returned.
+func (info *Info) loadPlugins(smgr Manager) error {
+	names, err := filepath.Glob(filepath.Join(info.PluginDir, "*.so"))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(names)
+
+	if info.loadedPlugins == nil {
+		info.loadedPlugins = map[string]struct{}{}
+	}
+
+	var firstErr error
+	for _, name := range names {
+		if _, ok := info.loadedPlugins[name]; ok {
+			continue
+		}
+
+		// Go plugins cannot be unloaded, and a failed load cannot be retried
+		// meaningfully, so never try the same file twice.
+		info.loadedPlugins[name] = struct{}{}
+
+		err := loadPlugin(name, smgr)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func loadPlugin(name string, smgr Manager) error {
+	p, err := plugin.Open(name)
+	if err != nil {
+		return err
+	}
+
+	sym, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return err
+	}
+
+	var f func(Manager)
+	switch s := sym.(type) {
+	case func(Manager):
+		f = s
+	case *func(Manager):
+		f = *s
+	default:
+		return fmt.Errorf("plugin %s: %s has type %T, expected func(service.Manager)", name, pluginSymbol, sym)
+	}
+
+	if f == nil {
+		return fmt.Errorf("plugin %s: %s is nil", name, pluginSymbol)
+	}
+
+	go f(smgr)
+	return nil
+}
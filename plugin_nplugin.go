@@ -0,0 +1,13 @@
+//go:build !serviceplugin
+// +build !serviceplugin
+
+package service
+
+import "fmt"
+
+// Plugin support is only built with the serviceplugin build tag, as the plugin
+// package requires cgo and dynamic linking, which would otherwise be imposed
+// on every program using this package.
+func (info *Info) loadPlugins(smgr Manager) error {
+	return fmt.Errorf("plugin support not built; rebuild with -tags serviceplugin")
+}
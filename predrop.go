@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Files requested with Manager.PreDropOpen, which are opened when privileges
+// are dropped.
+type preDropFiles struct {
+	mutex    sync.Mutex
+	requests []preDropRequest
+	files    map[string]*os.File
+	dropped  bool
+}
+
+type preDropRequest struct {
+	path  string
+	flags int
+}
+
+// Records a request to open a file when privileges are dropped. Requests for a
+// path which has already been requested with the same flags are ignored; a
+// request for it with different flags is an error.
+func (p *preDropFiles) request(path string, flags int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.dropped {
+		return fmt.Errorf("cannot open %s before dropping privileges: privileges already dropped", path)
+	}
+
+	for _, r := range p.requests {
+		if r.path == path {
+			if r.flags != flags {
+				return fmt.Errorf("cannot open %s before dropping privileges: already requested with different flags", path)
+			}
+			return nil
+		}
+	}
+
+	p.requests = append(p.requests, preDropRequest{path, flags})
+	return nil
+}
+
+// Opens the requested files which have not yet been opened. Called by
+// DropPrivileges while privileges are still held. If a file cannot be opened,
+// an error is returned, and the files already opened are kept so that
+// DropPrivileges can be retried.
+func (p *preDropFiles) open() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.files == nil {
+		p.files = map[string]*os.File{}
+	}
+
+	for _, r := range p.requests {
+		if _, ok := p.files[r.path]; ok {
+			continue
+		}
+
+		f, err := os.OpenFile(r.path, r.flags, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot open %s before dropping privileges: %v", r.path, err)
+		}
+
+		p.files[r.path] = f
+	}
+
+	return nil
+}
+
+// Records that privileges have been dropped, after which no further requests
+// are accepted.
+func (p *preDropFiles) setDropped() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dropped = true
+}
+
+// Returns the files opened so far, keyed by path.
+func (p *preDropFiles) get() map[string]*os.File {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	files := make(map[string]*os.File, len(p.files))
+	for path, f := range p.files {
+		files[path] = f
+	}
+
+	return files
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreDropRequestAfterDrop(t *testing.T) {
+	var p preDropFiles
+	p.setDropped()
+
+	err := p.request(os.DevNull, os.O_RDONLY)
+	if err == nil {
+		t.Fatal("request succeeded after privileges were dropped")
+	}
+}
+
+// A failed open keeps the files already opened, so that it can be retried.
+func TestPreDropRetry(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present")
+	missing := filepath.Join(dir, "missing")
+	err := os.WriteFile(present, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p preDropFiles
+	for _, path := range []string{present, missing} {
+		err = p.request(path, os.O_RDONLY)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = p.open()
+	if err == nil {
+		t.Fatal("open succeeded with a missing file")
+	}
+
+	f := p.get()[present]
+	if f == nil {
+		t.Fatal("file opened before the failure was not kept")
+	}
+
+	err = os.WriteFile(missing, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.open()
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	files := p.get()
+	if files[present] != f {
+		t.Fatal("file was reopened on retry")
+	}
+	if files[missing] == nil {
+		t.Fatal("missing file was not opened on retry")
+	}
+
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func TestPreDropFilesCopy(t *testing.T) {
+	var p preDropFiles
+	err := p.request(os.DevNull, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.get()[os.DevNull].Close()
+
+	files := p.get()
+	delete(files, os.DevNull)
+	files["/other"] = nil
+
+	files = p.get()
+	if len(files) != 1 || files[os.DevNull] == nil {
+		t.Fatalf("modifying the returned map changed the files: %v", files)
+	}
+}
+
+func TestPreDropConflictingFlags(t *testing.T) {
+	var p preDropFiles
+	err := p.request(os.DevNull, os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.request(os.DevNull, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("repeated request: %v", err)
+	}
+
+	err = p.request(os.DevNull, os.O_WRONLY)
+	if err == nil {
+		t.Fatal("request with different flags succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Whether the service is ready; published as service.ready.
+var readyVar atomic.Bool
+
+// Tracks the readiness of a service; see Manager.SetReady.
+type readiness struct {
+	mutex    sync.Mutex
+	started  bool
+	notReady bool
+}
+
+// Records that SetStarted has been called. Returns false if it had already
+// been called.
+func (r *readiness) setStarted() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.started {
+		return false
+	}
+
+	r.started = true
+	readyVar.Store(!r.notReady)
+	return true
+}
+
+// Sets the readiness of the service. Returns true if the service has started
+// and its readiness has changed.
+func (r *readiness) set(ready bool) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.notReady == !ready {
+		return false
+	}
+
+	r.notReady = !ready
+	if !r.started {
+		return false
+	}
+
+	readyVar.Store(ready)
+	return true
+}
+
+func (r *readiness) isReady() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.started && !r.notReady
+}
+
+// Logs a change in readiness.
+func (info *Info) logReadiness(ready bool) {
+	if ready {
+		info.logger().Info("service ready", "service", info.Name)
+	} else {
+		info.logger().Info("service not ready", "service", info.Name)
+	}
+}
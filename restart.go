@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Set in the environment of a process started by Manager.Restart to the
+// number of times the service has been restarted.
+const restartedEnv = "_SERVICE_RESTARTED"
+
+// Set in the environment of a process started by Manager.Restart to the file
+// descriptor of the file written by Info.OnSnapshot.
+const restoreFDEnv = "_SERVICE_RESTORE_FD"
+
+// Set in the environment of a process started by Manager.Restart to a
+// comma-separated list of the file descriptors of the files returned by
+// Info.InheritFiles.
+const inheritedFDsEnv = "INHERITED_FDS"
+
+// Returned by runInteractively when the service has stopped because
+// Manager.Restart was called.
+var errRestart = errors.New("service restart requested")
+
+func (h *ihandler) Restart() error {
+	if !canRestart {
+		return errNotSupported
+	}
+
+	if h.changedIDs {
+		return fmt.Errorf("cannot restart after privileges have been dropped")
+	}
+
+	select {
+	case h.restartChan <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (h *ihandler) InheritedFiles() []*os.File {
+	return h.info.inheritedFiles
+}
+
+// Returns true if this process was started by Manager.Restart.
+func isRestarted() bool {
+	return os.Getenv(restartedEnv) != ""
+}
+
+// Returns the environment for a process started by Manager.Restart, without
+// the variables describing any previous restart.
+func restartEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if hasEnvName(kv, restartedEnv) || hasEnvName(kv, restoreFDEnv) || hasEnvName(kv, inheritedFDsEnv) {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return append(env, restartedEnv+"="+strconv.FormatInt(restartCount.Value()+1, 10))
+}
+
+func hasEnvName(kv, name string) bool {
+	return len(kv) > len(name) && kv[:len(name)] == name && kv[len(name)] == '='
+}
+
+// In a process started by Manager.Restart, collects any inherited files and
+// passes any snapshot file to OnRestore.
+func (info *Info) restore() error {
+	if n, err := strconv.ParseInt(os.Getenv(restartedEnv), 10, 64); err == nil {
+		restartCount.Set(n)
+	}
+
+	fdStr := os.Getenv(restoreFDEnv)
+	inheritedStr := os.Getenv(inheritedFDsEnv)
+	os.Unsetenv(restartedEnv)
+	os.Unsetenv(restoreFDEnv)
+	os.Unsetenv(inheritedFDsEnv)
+
+	if inheritedStr != "" {
+		for _, s := range strings.Split(inheritedStr, ",") {
+			fd, err := strconv.Atoi(s)
+			if err != nil || fd < 0 {
+				return fmt.Errorf("invalid %s: %q", inheritedFDsEnv, inheritedStr)
+			}
+
+			info.inheritedFiles = append(info.inheritedFiles, inheritFD(fd))
+		}
+	}
+
+	if fdStr == "" {
+		return nil
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil || fd < 0 {
+		return fmt.Errorf("invalid %s: %q", restoreFDEnv, fdStr)
+	}
+
+	f := os.NewFile(uintptr(fd), "snapshot")
+	defer f.Close()
+
+	if info.OnRestore == nil {
+		return nil
+	}
+
+	err = info.OnRestore(f)
+	if err != nil {
+		return fmt.Errorf("cannot restore state: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// Package runit generates runit service directories for services.
+//
+// Importing this package registers the "runit-setup" service command, which
+// creates a service directory for the service under /etc/sv.
+package runit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+// The directory in which the "runit-setup" command creates service
+// directories.
+const ServiceDir = "/etc/sv"
+
+func init() {
+	service.RegisterCommand("runit-setup", func(info *service.Info) error {
+		return GenerateRunScript(filepath.Join(ServiceDir, info.Name), info)
+	})
+}
+
+// Creates a runit service directory for the service described by info at dir,
+// containing run and finish scripts and a log/run script which logs via
+// svlogd to /var/log/<name>. The service is run with the settings it was
+// installed with; see service.Config.Args. If Config.UID is set, the service is
+// run as that user (and Config.GID, if set) using chpst, unless it must chroot,
+// in which case it is started as root and drops privileges itself.
+//
+// Fails if dir already contains a run script.
+func GenerateRunScript(dir string, info *service.Info) error {
+	err := os.MkdirAll(filepath.Join(dir, "log"), 0755)
+	if err != nil {
+		return err
+	}
+
+	var run strings.Builder
+	run.WriteString("#!/bin/sh\nexec 2>&1\nexec ")
+	if user := chpstUser(info); user != "" {
+		run.WriteString("chpst -u " + svcfile.ShellQuote(user) + " ")
+	}
+	run.WriteString(svcfile.ShellJoin(svcfile.Command(info)) + "\n")
+
+	err = writeScript(filepath.Join(dir, "run"), run.String())
+	if err != nil {
+		return err
+	}
+
+	err = writeScript(filepath.Join(dir, "finish"),
+		"#!/bin/sh\n# Called by runsv with the exit code and status of the service.\nexit 0\n")
+	if err != nil {
+		return err
+	}
+
+	logDir := svcfile.ShellQuote(filepath.Join("/var/log", info.Name))
+	return writeScript(filepath.Join(dir, "log", "run"),
+		"#!/bin/sh\n[ -d "+logDir+" ] || mkdir -p "+logDir+"\nexec svlogd -tt "+logDir+"\n")
+}
+
+// Returns the user[:group] argument for chpst -u, or "" if no user is
+// configured.
+func chpstUser(info *service.Info) string {
+	user, gid := svcfile.User(info)
+	if user != "" && gid != "" {
+		user += ":" + gid
+	}
+	return user
+}
+
+func writeScript(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists", path)
+		}
+		return err
+	}
+
+	_, err = f.WriteString(content)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,22 @@
+package runit
+
+import (
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile/svcfiletest"
+)
+
+func TestGenerateRunScript(t *testing.T) {
+	base := service.Info{
+		Name:    "example",
+		ExePath: "/usr/local/bin/example",
+	}
+
+	svcfiletest.GoldenDirTest(t, base, []string{"run", "finish", "log/run"}, []svcfiletest.Case{
+		{Name: "simple"},
+		{Name: "user", Args: []string{"-uid=example", "-gid=example", "-state-dir=example",
+			"-set-env=GREETING=it's $HOME", "-command=runit-setup"}},
+		{Name: "chroot", Args: []string{"-uid=example", "-chroot=/var/empty"}},
+	}, GenerateRunScript)
+}
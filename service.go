@@ -20,27 +20,51 @@
 // this functionality itself if needed. This reduces dependency closure size by allowing
 // this package to no longer depend on net/http.
 //
+// For the same reason, this package does not acquire D-Bus names, which would
+// require a D-Bus client library such as [godbus]. A service which systemd
+// expects to take a bus name (BusName=) can acquire it on its own connection
+// before calling Manager.SetStarted. Nor does it load eBPF programs, which
+// would require a library such as [cilium/ebpf]; a service can load and attach
+// any programs it needs before calling Manager.DropPrivileges, while it still
+// has the privileges to do so.
+//
+// v3 requires Go 1.21 or later, as service lifecycle messages are logged
+// using [log/slog]. See Info.Logger.
+//
 // # Platform-Specific Configuration Variables
 //
 // Some fields in [Config] are platform-specific. The fields are present on all
 // platforms as Go provides no simple way to omit fields in structure
 // definitions on certain platforms. The "platform" annotation on a field
 // denotes if a field is platform-specific. If this annotation is omitted, the
-// field is supported on all platforms. You can pass the "platform" annotation
-// to [UsingPlatform] to determine if a field is currently applicable.
+// field is supported on all platforms. Values are "unix", "windows", "launchd"
+// or the name of a specific OS as used by GOOS (e.g. "openbsd"). You can pass the
+// "platform" annotation to [UsingPlatform] to determine if a field is currently
+// applicable, or to [PlatformDoc] to obtain a description suitable for help
+// text.
 //
 // [configurable]: https://github.com/hlandau/configurable
 // [easyconfig]: https://github.com/hlandau/easyconfig
+// [godbus]: https://github.com/godbus/dbus
+// [cilium/ebpf]: https://github.com/cilium/ebpf
 package service // import "gopkg.in/hlandau/service.v3"
 
 import (
+	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
+	"reflect"
+	"runtime/debug"
 	"runtime/pprof"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -48,39 +72,202 @@ import (
 	"gopkg.in/hlandau/svcutils.v1/exepath"
 )
 
+var errNotSupported = fmt.Errorf("not supported")
+
 type nullWriter struct{}
 
 func (nw nullWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+var (
+	startTime    time.Time
+	statusVar    expvar.Map
+	versionVar   expvar.String
+	buildVar     expvar.String
+	restartCount *expvar.Int
+)
+
 func init() {
-	expvar.NewString("service.startTime").Set(time.Now().String())
+	startTime = time.Now()
+	expvar.NewString("service.startTime").Set(startTime.String())
+	expvar.Publish("service.uptime", expvar.Func(func() any {
+		return time.Since(startTime).String()
+	}))
+	expvar.Publish("service.status", &statusVar)
+	expvar.Publish("service.version", &versionVar)
+	expvar.Publish("service.build", &buildVar)
+	expvar.Publish("service.ready", expvar.Func(func() any {
+		return readyVar.Load()
+	}))
+
+	// The number of times the service has been restarted via Manager.Restart.
+	// Carried across each restart.
+	restartCount = expvar.NewInt("service.restartCount")
+}
+
+// Publishes the status of the named service. service.status maps the name of
+// each service in the process to its status.
+func publishStatus(name, status string) {
+	v := new(expvar.String)
+	v.Set(status)
+	statusVar.Set(name, v)
 }
 
 // This function should typically be called directly from func main(). It takes
 // care of all housekeeping for running services and handles service lifecycle.
+//
+// If the service fails, the error is logged and the process exits with a
+// non-zero exit status. Use Run to handle the error instead.
 func Main(info *Info) {
 	info.main()
 }
 
+// Like Main, but returns any error instead of logging it and exiting. This
+// allows a service to be run from tests.
+//
+// Note that Run may still not return in some circumstances: when forking,
+// the parent process exits once the child has started, and restarting via
+// Manager.Restart replaces the current process.
+func Run(info *Info) error {
+	return info.run()
+}
+
+// Runs the service using the given Manager, returning once it has stopped.
+// Unlike Run, this performs none of the process-level housekeeping such as
+// executing service commands, daemonizing, dropping privileges or handling
+// signals; the service interacts only with smgr. If NewFunc is specified, the
+// Runnable it returns is driven as it would be by Run.
+//
+// This is intended for testing, in conjunction with the servicetest package.
+func RunWithManager(info *Info, smgr Manager) error {
+	err := info.setRunFunc()
+	if err != nil {
+		return err
+	}
+
+	return info.callRunFunc(info.RunFunc, smgr)
+}
+
 // The interface between the service library and the application-specific code.
 // The application calls the methods in the provided instance of this interface
 // at various stages in its lifecycle.
 type Manager interface {
 	// Must be called when the service is ready to drop privileges.
-	// This must be called before SetStarted().
+	// This must be called before SetStarted(). Once it has succeeded, further
+	// calls do nothing and return nil; if it fails, it may be called again.
 	DropPrivileges() error
 
+	// Requests that the file at path be opened with the given flags, as for
+	// os.OpenFile, when DropPrivileges is called, while privileges are still
+	// held. This allows files which will be inaccessible once privileges have
+	// been dropped, for example because they are outside the chroot, to be
+	// used by the service. If a file cannot be opened, DropPrivileges fails.
+	// Returns an error if privileges have already been dropped, or if the
+	// same path has already been requested with different flags.
+	PreDropOpen(path string, flags int) error
+
+	// Returns the files opened as requested with PreDropOpen, keyed by path.
+	// Returns an empty map before DropPrivileges has been called.
+	PreDropFiles() map[string]*os.File
+
 	// Must be called by a service payload when it has finished starting.
+	// Calls after the first have no effect.
 	SetStarted()
 
+	// Marks the service as ready or not ready. A service is ready once it has
+	// called SetStarted, but may later become temporarily unready, for example
+	// after losing a database connection, by calling SetReady(false), and
+	// ready again by calling SetReady(true). Changes in readiness are logged
+	// and, under systemd, reported using READY=0 and READY=1 notifications.
+	// (READY=0 is not a standard notification, but is logged by systemd.)
+	SetReady(ready bool)
+
+	// Returns true if SetStarted has been called and the service has not been
+	// marked as not ready using SetReady.
+	IsReady() bool
+
 	// A service payload must stop when this channel is closed.
 	StopChan() <-chan struct{}
 
+	// Returns true once the service has been asked to stop. If
+	// Config.DrainTimeout is set, the stop channel is only closed once it has
+	// elapsed; in the meantime, the service should finish existing work but
+	// accept no new work, for example by failing health checks so that load
+	// balancers stop sending it requests.
+	IsDraining() bool
+
 	// Called by a service payload to provide a single line of information on the
 	// current status of that service.
 	SetStatus(status string)
+
+	// Sets the status of a single component of the service, such as a
+	// database connection or HTTP server. The status reported for the service
+	// consists of the status set by SetStatus followed by the status of each
+	// component, in the form "component: status", separated by "; ".
+	SetComponentStatus(component, status string)
+
+	// Removes the status of a component set by SetComponentStatus.
+	ClearComponentStatus(component string)
+
+	// Returns the named credential, read from the source configured in
+	// Config.Credentials, or from the systemd credentials directory if the
+	// credential is not configured. Configured credentials are read when
+	// DropPrivileges is called, before privileges are dropped, so remain
+	// available afterwards.
+	GetCredential(name string) ([]byte, error)
+
+	// Returns up to n of the most recent statuses set by SetStatus and
+	// SetComponentStatus, oldest first. The number of statuses kept is
+	// limited by Config.StatusHistorySize.
+	StatusHistory(n int) []StatusEntry
+
+	// Returns any listening sockets passed to the service by the service
+	// manager. Currently, this returns the sockets retrieved from launchd if
+	// Config.LaunchdSocket is set, and the sockets passed by systemd socket
+	// activation. Only valid after DropPrivileges has been called.
+	SocketListeners() []net.Listener
+
+	// Requests that the service restart itself by re-executing its own
+	// binary, for example after the binary has been upgraded. The stop channel
+	// is closed as for a normal stop; once RunFunc has returned, Info.OnSnapshot
+	// is called (if set) and the process replaces itself with a new instance
+	// of the binary, with the same PID, arguments and environment.
+	//
+	// The new process must be able to start from scratch in the state left by
+	// the old one.
+	//
+	// Returns an error if restarting is not supported on the current platform,
+	// or if DropPrivileges has changed the UID of the process or chrooted it,
+	// as the new process would be unable to find its binary or to repeat the
+	// privileged parts of its startup.
+	Restart() error
+
+	// Returns any files, such as listening sockets, passed to this process by
+	// the process it replaced when restarting via Restart. See
+	// Info.InheritFiles. Returns nil if there are none.
+	InheritedFiles() []*os.File
+
+	// Returns the path of the runtime directory created for the service, or ""
+	// if Config.RuntimeDir is not set. If the service has been chrooted, the
+	// path is relative to the chroot.
+	RuntimeDir() string
+
+	// Like RuntimeDir, but for the directories configured by Config.StateDir,
+	// Config.CacheDir and Config.LogDir respectively.
+	StateDir() string
+	CacheDir() string
+	LogDir() string
+
+	// Starts f in a new goroutine. The context passed to f is cancelled when
+	// the stop channel is closed; f should return promptly once this happens.
+	// Returns an error if the service is already stopping or Wait has been
+	// called, in which case f is not started.
+	Go(f func(ctx context.Context)) error
+
+	// Blocks until all goroutines started by Go have returned. If any of them
+	// panicked, returns a *PanicError for the first such panic.
+	Wait() error
 }
 
 // Used only by the NewFunc interface.
@@ -102,6 +289,20 @@ type StatusSource interface {
 	StatusChan() <-chan string
 }
 
+// An upgrade interface for Runnable, implementation of which is optional.
+type Drainer interface {
+	// Called when the service is asked to stop, before Stop is called. The
+	// runnable should stop accepting new work and close the returned channel
+	// once in-flight work has finished. Stop is called once the channel is
+	// closed, or once Config.ShutdownTimeout has expired.
+	//
+	// If Config.DrainTimeout is set, Drain is only called once it has elapsed
+	// and the stop channel has been closed, so the service may take up to
+	// DrainTimeout plus ShutdownTimeout to stop. A runnable which should stop
+	// accepting work as soon as draining begins can poll Manager.IsDraining.
+	Drain() <-chan struct{}
+}
+
 // Configuration variables which control how a service is run.
 type Config struct {
 	// If this is non-empty, CPU profiling is initiated on startup and the
@@ -121,27 +322,475 @@ type Config struct {
 	// Stderr is not set) to /dev/null.
 	Daemon bool `help:"Run as daemon? (doesn't fork)" platform:"unix"`
 
-	// UNIX: Fork. Implies Daemon.
+	// UNIX: Fork. Implies Daemon. The parent process waits until the child has
+	// called SetStarted and then exits, or exits with an error if the child
+	// fails to start. Ignored when running under launchd.
 	Fork bool `help:"Fork? (implies daemon)" platform:"unix"`
 
+	// UNIX: How long the parent process waits for the child to start when
+	// forking before killing it and failing. Defaults to 30 seconds if zero.
+	ForkTimeout time.Duration `help:"Time to wait for forked child to start" platform:"unix"`
+
+	// Linux: If non-empty, the kernel thread name (as shown in /proc/<pid>/comm
+	// and by tools such as top) is set to this value at startup. Names longer
+	// than 15 bytes are truncated.
+	ThreadName string `help:"Kernel thread name to set at startup" platform:"linux"`
+
+	// UNIX: If non-zero, the soft and hard limits on the number of open files
+	// (RLIMIT_NOFILE) are set to this value at startup. On Linux, the value is
+	// capped to the system-wide maximum. Raising the hard limit generally
+	// requires root.
+	MaxOpenFiles uint64 `help:"Maximum number of open files" platform:"unix"`
+
+	// UNIX: If set, all memory of the process is locked into RAM at startup
+	// using mlockall(2), so that it is never swapped to disk. Use this to
+	// prevent secrets from being written to swap. This happens before
+	// privileges are dropped, as it generally requires root or CAP_IPC_LOCK.
+	LockMemory bool `help:"Lock memory to prevent swapping (mlockall)" platform:"unix"`
+
+	// UNIX: The maximum size of core dump files in bytes (RLIMIT_CORE). -1
+	// means no limit. If zero, core dumps are disabled, except in
+	// SupervisedMode, where the limit is left unchanged.
+	CoreDumpSize int64 `help:"Maximum core dump size in bytes (-1: unlimited, 0: disabled)" platform:"unix"`
+
+	// Linux: If non-empty, the directory in which core dumps are written. If
+	// the service is running as root, the system-wide core pattern
+	// (/proc/sys/kernel/core_pattern) is set to write core dumps to this
+	// directory at startup; otherwise, this is ignored. Note that this
+	// affects all processes on the system.
+	CoreDumpDir string `help:"Directory for core dumps (sets system-wide core_pattern)" platform:"linux"`
+
+	// UNIX: Prefix for the process title, as shown by ps, which is set to the
+	// current status. If empty, the service name followed by ": " is used. If
+	// there is no status, the process title is just the prefix.
+	ProcessTitlePrefix string `help:"Prefix for process title" platform:"unix"`
+
+	// The number of statuses kept for Manager.StatusHistory. If zero, 100 are
+	// kept. If negative, no history is kept.
+	StatusHistorySize int `help:"Number of status messages to keep in history"`
+
+	// Linux: Existing namespaces for the service to enter at startup, before
+	// daemonizing, for example to run the service inside a network namespace
+	// created with "ip netns add". Generally requires root.
+	//
+	// As setns(2) only affects a single thread, the namespaces are entered
+	// on one thread, which then re-executes the service binary. Entering a
+	// "mnt" namespace is normally refused by the kernel for multithreaded
+	// processes, which includes all Go programs. Entering a "pid" namespace
+	// only affects processes subsequently created, so only has an effect on
+	// the service itself if Fork is set.
+	Namespaces []NamespaceEntry `help:"Namespaces to enter at startup" platform:"linux"`
+
+	// Linux: New namespaces to create for the service at startup, isolating it
+	// from the rest of the system. Values are "net", "mnt", "uts", "ipc",
+	// "pid" and "user". For example, unsharing "net" gives the service a
+	// private network stack containing only a loopback interface. Except for
+	// "user", this generally requires root.
+	//
+	// The kernel only allows a single-threaded process to create a new user
+	// namespace, and creates most other namespaces for the calling thread only,
+	// whereas Go programs are always multithreaded. If Fork is set, the child
+	// is therefore created in the new namespaces directly. Otherwise, they are
+	// created after daemonizing on a single thread, which then re-executes the
+	// service binary; in this case, "user" is not supported, and "pid" only
+	// affects processes subsequently created by the service.
+	//
+	// When a user namespace is created, UID and GID (or the current user and
+	// group, if UID is not set) inside the namespace are mapped to the current
+	// user and group outside it.
+	UnshareNamespaces []string `help:"Namespaces to unshare at startup (net, mnt, uts, ipc, pid, user)" platform:"linux"`
+
+	// Linux: If set, the service transitions to this AppArmor profile at
+	// startup. As AppArmor confines individual threads, the transition is made
+	// by re-executing the service binary, and so happens before privileges are
+	// dropped; the profile must permit the service to drop privileges and, if
+	// Fork is set, to execute itself. Ignored, with a log message, if AppArmor
+	// is not enabled.
+	AppArmorProfile string `help:"AppArmor profile to transition to at startup" platform:"linux"`
+
+	// Linux: If set, the service transitions to this SELinux context, e.g.
+	// "system_u:system_r:foo_t:s0", at startup. As for AppArmorProfile, this
+	// is done by re-executing the service binary before privileges are
+	// dropped. Ignored, with a log message, if SELinux is not enabled.
+	SELinuxContext string `help:"SELinux context to transition to at startup" platform:"linux"`
+
+	// Linux: If set, fresh tmpfs filesystems are mounted over /tmp and
+	// /var/tmp at startup, so that the service does not share temporary files
+	// with other processes. Requires UnshareNamespaces to include "mnt", and
+	// generally requires root.
+	PrivateTmp bool `help:"Mount private /tmp and /var/tmp (requires mnt namespace)" platform:"linux"`
+
+	// Linux: If set, the service becomes a child subreaper at startup, so that
+	// orphaned descendants are reparented to it rather than to init, and all
+	// child processes are reaped as they exit; see Info.OnChildExit. Children
+	// started using os/exec must be started with StartCommand, or Cmd.Wait may
+	// fail for them. Requires /proc to be available, including after
+	// chrooting.
+	SubReaper bool `help:"Become a child subreaper" platform:"linux"`
+
 	// UNIX: If non-empty, path to a file to write the process PID to.
 	PIDFile string `help:"Write PID to file with given filename and hold a write lock" platform:"unix"`
 
+	// UNIX: If set and Fork is set, the PID file is also opened by the parent
+	// process before forking, as traditional SysV daemons do. The file briefly
+	// contains the parent's PID and is then rewritten by the child with its
+	// own PID.
+	//
+	// This allows a conflicting running instance to be detected, and reported
+	// on the terminal, before forking. The file is passed to the child, which
+	// shares the lock, so the file remains locked throughout. On Linux this uses
+	// an open file description lock (Linux 3.15 or later) and elsewhere a flock
+	// lock; either conflicts with the lock taken when PIDFileParent is not set.
+	// Without this option, the PID file is only ever written by the daemon
+	// itself.
+	PIDFileParent bool `help:"Open PID file in parent before forking" platform:"unix"`
+
+	// UNIX: Paths to additional PID files, for setups which expect the PID at a
+	// well-known location as well as at PIDFile. Each is written and locked in
+	// the same way as PIDFile and closed on shutdown.
+	ExtraPIDFiles []string `help:"Additional PID files to write and lock" platform:"unix"`
+
 	// UNIX: If not "/", the directory to chroot into. Only used if dropping
 	// privileges (i.e., if UID is non-empty).
 	Chroot string `help:"Chroot to a directory (must set UID, GID) ('/' disables)" platform:"unix"`
 
+	// UNIX: Before chrooting, the chroot directory is checked to ensure that it
+	// cannot be modified by users other than root and the service user. Setting
+	// this skips the check, which should only be necessary for unusual setups.
+	SkipChrootSafetyCheck bool `help:"Skip chroot directory permission checks" platform:"unix"`
+
 	// UNIX: Keep stderr open if Daemon is set and do not remap it to /dev/null.
 	Stderr bool `help:"Keep stderr open when daemonizing" platform:"unix"`
 
-	// Windows: Service control command. Can be used to install or uninstall a
-	// service, or start or stop it. If empty, run the service normally.
-	// The package automatically detects if it is running under the service manager
-	// or as a normal process.
-	Command string `help:"Service command (install, uninstall, start, stop)" platform:"windows"`
+	// UNIX: The signals which cause the service to stop gracefully. If empty,
+	// SIGINT and SIGTERM are used.
+	StopSignals []os.Signal `help:"Signals which cause the service to stop" platform:"unix"`
+
+	// The maximum time to wait for a Runnable implementing Drainer to finish
+	// draining before calling Stop. If zero, there is no limit. This period
+	// begins once the stop channel has been closed, after any DrainTimeout.
+	ShutdownTimeout time.Duration `help:"Maximum time to wait for in-flight work when stopping"`
+
+	// If non-zero, when the service is asked to stop, Manager.IsDraining
+	// returns true immediately, but the stop channel is only closed after this
+	// period. For a Runnable, Drainer.Drain is called after this period, not
+	// when it begins; see Drainer.
+	DrainTimeout time.Duration `help:"Time to drain before stopping"`
+
+	// If set, a second stop signal received while the service is stopping
+	// causes the process to exit immediately with a non-zero exit status,
+	// without waiting for the service to finish stopping.
+	ForceExitOnSecondSignal bool `help:"Exit immediately on second stop signal"`
+
+	// If non-zero and Info.HealthCheck is set, the interval at which the
+	// health check is run.
+	HealthCheckInterval time.Duration `help:"Interval between health checks"`
+
+	// The action taken when the health check fails repeatedly: "log" (the
+	// default) only logs the failure, "stop" stops the service and "restart"
+	// restarts it as for Manager.Restart.
+	HealthCheckFailureAction string `help:"Action on repeated health check failure (log, stop, restart)"`
+
+	// UNIX: By default, the Go runtime dumps the stacks of all goroutines to
+	// stderr and exits when SIGQUIT is received. If this is set, SIGQUIT is
+	// instead ignored, other than logging a message.
+	NoDumpGoroutinesOnQuit bool `help:"Don't dump goroutines and exit on SIGQUIT" platform:"unix"`
+
+	// UNIX: If non-empty, the stacks of all goroutines are written to this file
+	// when SIGQUIT is received, replacing its contents, and the service
+	// continues running. Should be an absolute path, and is interpreted
+	// relative to the chroot, if any.
+	DumpGoroutinesFile string `help:"Dump goroutines to file on SIGQUIT" platform:"unix"`
+
+	// UNIX: Run under a supervisor such as s6 which expects the service to
+	// remain in the foreground. All daemonization steps are skipped, even if
+	// Daemon or Fork is set, and no PID file is written. Privileges are still
+	// dropped and stop signals are still handled.
+	SupervisedMode bool `help:"Run in foreground under a supervisor (implies no daemon, fork or PID file)" platform:"unix"`
+
+	// UNIX: Run under supervisord, which tracks the service by its PID and so
+	// loses track of it if it forks. This has the same effect as
+	// SupervisedMode, and is set automatically if the parent process appears
+	// to be supervisord.
+	SupervisordMode bool `help:"Run in foreground under supervisord (implies no daemon, fork or PID file)" platform:"unix"`
+
+	// UNIX: When running in a container (see IsContainer), the service does
+	// not daemonize, fork or write a PID file, as the container runtime
+	// expects it to remain in the foreground. It is otherwise initialised as
+	// usual. If set, Daemon, Fork and PIDFile are honoured anyway.
+	ForceDaemon bool `help:"Daemonize even when running in a container" platform:"unix"`
+
+	// Linux: If non-empty, the path of a cgroup v2 cgroup which the process
+	// moves itself into at startup. Relative paths are interpreted relative to
+	// /sys/fs/cgroup, and absolute paths must be within it. The path must not
+	// contain "..". The cgroup must already exist and be writable by the
+	// process; it may, for example, be delegated by systemd or container
+	// infrastructure, in which case root is not required.
+	CgroupPath string `help:"cgroup v2 path to join at startup" platform:"linux"`
+
+	// Linux: If positive and CgroupPath is set, the memory limit in bytes to
+	// set on the cgroup (memory.max).
+	CgroupMemoryMax int64 `help:"cgroup memory limit in bytes" platform:"linux"`
+
+	// Linux: If positive and CgroupPath is set, the CPU quota to set on the
+	// cgroup (cpu.max), as a percentage of a single CPU. Values above 100 allow
+	// the use of more than one CPU.
+	CgroupCPUQuota int `help:"cgroup CPU quota as a percentage of one CPU" platform:"linux"`
+
+	// If set, all environment variables are removed at startup, other than
+	// those listed in KeepEnvVars and a minimal set needed for operation: PATH,
+	// HOME and TMPDIR on UNIX; PATH, PATHEXT, SystemRoot, SystemDrive, windir,
+	// ComSpec, TEMP and TMP on Windows; and variables used to communicate with
+	// the service manager, such as NOTIFY_SOCKET. This prevents secrets in the
+	// environment of the process which started the service from being
+	// inherited by it.
+	ClearEnv bool `help:"Clear environment variables at startup"`
+
+	// Names of environment variables to keep when ClearEnv is set.
+	KeepEnvVars []string `help:"Environment variables to keep when clearing environment"`
+
+	// Environment variables to set at startup, after any clearing of the
+	// environment due to ClearEnv.
+	SetEnv map[string]string `help:"Environment variables to set"`
+
+	// Directories to prepend to PATH at startup, in order.
+	PrependPath []string `help:"Directories to prepend to PATH"`
+
+	// The sources of credentials, such as passwords and keys, which the
+	// service retrieves using Manager.GetCredential, keyed by credential name.
+	// This allows the way in which secrets are delivered to be changed
+	// without changing the service. Credentials not listed here are read from
+	// the systemd credentials directory; see SystemdCredential.
+	Credentials map[string]CredentialSource `help:"Sources of credentials"`
+
+	// UNIX: If non-empty, a runtime directory for sockets and other transient
+	// files is created at startup, before privileges are dropped, with mode
+	// 0755 and owned by UID and GID. Relative paths are interpreted relative to
+	// /var/run. The mode and owner of an existing directory are only changed
+	// if it is directly under /var/run. If the RUNTIME_DIRECTORY environment
+	// variable is set, as it is when systemd's RuntimeDirectory= is used, that
+	// directory is used instead. The path is available via Manager.RuntimeDir.
+	RuntimeDir string `help:"Runtime directory to create" platform:"unix"`
+
+	// UNIX: If non-empty, a directory for persistent state is created at
+	// startup in the same way as RuntimeDir, but with mode 0700. Relative
+	// paths are interpreted relative to /var/lib. If the STATE_DIRECTORY
+	// environment variable is set, as it is when systemd's StateDirectory= is
+	// used, that directory is used instead. The path is available via
+	// Manager.StateDir.
+	StateDir string `help:"State directory to create" platform:"unix"`
+
+	// UNIX: Like StateDir, but for cached data. Relative paths are interpreted
+	// relative to /var/cache, and CACHE_DIRECTORY (systemd's CacheDirectory=)
+	// is used if set. The path is available via Manager.CacheDir.
+	CacheDir string `help:"Cache directory to create" platform:"unix"`
+
+	// UNIX: Like StateDir, but for log files. Relative paths are interpreted
+	// relative to /var/log, and LOGS_DIRECTORY (systemd's LogsDirectory=) is
+	// used if set. The path is available via Manager.LogDir.
+	LogDir string `help:"Log directory to create" platform:"unix"`
+
+	// Service control command. Can be used to install or remove a service, or
+	// start or stop it. If empty, run the service normally. The command is
+	// carried out by Info.Backend; by default, this is the Windows service
+	// control manager on Windows, and systemd, OpenRC or launchd on UNIX
+	// systems, as detected.
+	//
+	// The "restart" command stops and then starts the service.
+	//
+	// The "update" command updates the metadata of an installed service, such
+	// as its title and description, without reinstalling it. It is only
+	// supported on Windows.
+	//
+	// The "install-unit" command installs a systemd unit for the service
+	// regardless of the service manager detected. Installing under systemd
+	// requires the gopkg.in/hlandau/service.v3/unit package to be imported.
+	//
+	// The "sysvinit" command installs a SysV init script for the service in
+	// /etc/init.d. It requires the gopkg.in/hlandau/service.v3/sysvinit package
+	// to be imported.
+	//
+	// The "status" command prints the state of the installed service, as
+	// reported by the service manager. The process exits with status 0 if the
+	// service is running, 1 if it is stopped, 2 if it is starting or stopping
+	// and 3 if its state is unknown, including if the service manager could not
+	// be queried.
+	//
+	// Subpackages may register further commands; see RegisterCommand.
+	//
+	// On Windows, the package automatically detects if it is running under the
+	// service manager or as a normal process.
+	Command string `help:"Service command (install, remove, start, stop, restart, update, status, install-unit, sysvinit)"`
+
+	// If set and the service is not installed, it offers to install itself
+	// when run from a terminal rather than by the service manager: on Windows,
+	// as a Windows service, and on other platforms, as a systemd unit if
+	// systemd is in use. The user is asked to confirm unless ForceInstall is
+	// set. Once installed, the service is started via the service manager and
+	// the process exits.
+	AutoInstall bool `help:"Offer to install the service if it is not installed"`
+
+	// If set, AutoInstall installs the service without asking.
+	ForceInstall bool `help:"Install without asking when AutoInstall is set"`
+
+	// If set, installing the service, either via AutoInstall or the "install"
+	// command, only describes what would be done without doing it.
+	DryRun bool `help:"Describe installation without performing it"`
+
+	// If set, the "status" command prints the state of the service as a JSON
+	// object rather than as text.
+	JSON bool `help:"Print service status as JSON"`
+
+	// UNIX: If set, a Unix domain socket is opened at this path once the
+	// service has started, through which the service can be queried and
+	// controlled by sending JSON-encoded ManagementRequests, for example using
+	// the service-ctl tool. The socket is removed when the service stops. Only
+	// the service user and root may connect to it; it is created with mode
+	// 0600 and the credentials of each client are checked where the platform
+	// supports this.
+	//
+	// The socket is opened after privileges are dropped, so the path is
+	// relative to any chroot directory and must be writable by the service
+	// user. Setting this on Windows is an error, as access to the socket could
+	// not be restricted there.
+	ManagementSocket string `help:"Path of the management socket" platform:"unix"`
+
+	// Windows: If non-nil, the recovery actions the service control manager
+	// takes when the service fails. Applied when the service is installed or
+	// updated.
+	WindowsRecovery *WindowsRecoveryConfig `help:"Service recovery actions" platform:"windows"`
+
+	// Windows: If set, service start, stop and failure events are written to
+	// the Windows Application event log when running as a service. The event
+	// source is registered when the service is installed, so this must also be
+	// set when installing.
+	WindowsEventLog bool `help:"Write service events to the Windows event log" platform:"windows"`
+
+	// Windows: If non-zero, the service accepts pre-shutdown notifications,
+	// which are sent before system shutdown and allow a service more time to
+	// stop than a normal shutdown notification. This is the maximum time the
+	// service may take to stop after a pre-shutdown notification. It is
+	// registered with the service control manager when the service is installed
+	// or updated.
+	PreShutdownTimeout time.Duration `help:"Time allowed to stop on pre-shutdown notification" platform:"windows"`
+
+	// Windows: Arguments stored with the service when it is installed or
+	// updated, and passed to the executable every time the service control
+	// manager starts it.
+	ServiceArgs []string `help:"Arguments passed to the service when started" platform:"windows"`
+
+	// Windows: The names of services, such as "Tcpip" or "RpcSs", which the
+	// service control manager must start before this service. Applied when the
+	// service is installed or updated.
+	WindowsDependencies []string `help:"Services which must be started before this service" platform:"windows"`
+
+	// Windows: The account under which the service runs, e.g.
+	// `NT AUTHORITY\NetworkService` or `.\username`. If empty, the service
+	// runs as LocalSystem. Applied when the service is installed or updated.
+	WindowsServiceAccount string `help:"Account under which the service runs" platform:"windows"`
+
+	// Windows: The password for WindowsServiceAccount, if it requires one.
+	WindowsPassword string `help:"Password for the service account" platform:"windows"`
+
+	// macOS: Sockets for launchd to create on behalf of the service. These are
+	// included in the launchd property list generated for the service.
+	LaunchdSockets []LaunchdSocket `help:"Sockets for launchd to listen on" platform:"darwin"`
+
+	// macOS: If non-empty, the name of a socket created by launchd (see
+	// LaunchdSockets) to activate when dropping privileges. The resulting
+	// listeners are available via Manager.SocketListeners. Requires cgo.
+	LaunchdSocket string `help:"Name of launchd socket to activate" platform:"darwin"`
+
+	// OpenBSD: Paths to unveil(2) when dropping privileges. If non-empty, each
+	// path is unveiled with the given permissions and further unveiling is then
+	// blocked, so that only these paths remain visible to the service. Paths
+	// are interpreted relative to the chroot, if any.
+	UnveilPaths []UnveilPath `help:"Paths to unveil when dropping privileges" platform:"openbsd"`
+
+	// Linux: Paths to bind mount into the chroot when dropping privileges,
+	// after Info.ChrootSetup is called and the chroot has been checked for
+	// safety. Target directories (or files, if the source is a file) are
+	// created if they do not exist. Targets must not contain ".." or pass
+	// through symbolic links, and targets which are already mount points are
+	// skipped. The mounts are not removed when the service exits, and cannot
+	// be unmounted by the service once it has chrooted and dropped
+	// privileges; use Info.ChrootTeardown to remove them. Ignored if no chroot
+	// is performed.
+	ChrootBindMounts []BindMount `help:"Paths to bind mount into the chroot" platform:"linux"`
+
+	// Linux: Device files, such as "/dev/null" and "/dev/urandom", to bind
+	// mount at the same paths in the chroot, as for ChrootBindMounts.
+	ChrootDevices []string `help:"Devices to bind mount into the chroot" platform:"linux"`
+
+	// UNIX: Files to open read-only when dropping privileges, before any
+	// chroot, so that they remain available afterwards. They are returned by
+	// Manager.PreDropFiles, as for Manager.PreDropOpen.
+	PreChrootFiles []string `help:"Files to open before chrooting" platform:"unix"`
+
+	// The names of fields which have been set explicitly, even if to their
+	// zero values. Used by MergeConfig.
+	ExplicitlySet map[string]bool `json:"-"`
+}
+
+// Configures the actions the Windows service control manager takes when a
+// service fails. See Config.WindowsRecovery.
+//
+// Each action is one of "restart" (restart the service), "run" (run
+// CommandLine), "reboot" (reboot the computer, showing RebootMsg) or "none"
+// (the default).
+type WindowsRecoveryConfig struct {
+	FirstFailureAction      string // Action taken on the first failure.
+	SecondFailureAction     string // Action taken on the second failure.
+	SubsequentFailureAction string // Action taken on subsequent failures.
+
+	// Time to wait after a failure before taking the action.
+	Delay time.Duration
+
+	// Time without failures after which the failure count is reset to zero, in
+	// seconds. If zero, the failure count is never reset.
+	ResetPeriodSecs uint32
+
+	// Message broadcast to users before rebooting for the "reboot" action.
+	RebootMsg string
+
+	// Command line run for the "run" action.
+	CommandLine string
+}
+
+// A socket which launchd listens on for a service. See Config.LaunchdSockets.
+type LaunchdSocket struct {
+	// The name of the socket, used to retrieve it at runtime.
+	Name string
+
+	// "tcp", "udp" or "unix".
+	Network string
+
+	// For "tcp" and "udp", a host:port pair, where the host may be empty to
+	// listen on all interfaces. For "unix", the path of the socket.
+	Address string
 }
 
-// Returns true if a given platform name (e.g. "", "unix", "windows") is currently applicable.
+// A namespace to enter at startup. See Config.Namespaces.
+type NamespaceEntry struct {
+	Type string // "net", "mnt", "uts", "ipc" or "pid".
+	Path string // Path to the namespace, e.g. "/var/run/netns/foo" or "/proc/1/ns/net".
+}
+
+// A bind mount to be created in the chroot. See Config.ChrootBindMounts.
+type BindMount struct {
+	Source   string // The path to mount, outside the chroot.
+	Target   string // The path to mount it at, relative to the chroot.
+	ReadOnly bool   // Mount read-only?
+}
+
+// A path to be made visible to the service using unveil(2). See
+// Config.UnveilPaths.
+type UnveilPath struct {
+	Path        string // The path to unveil.
+	Permissions string // Some combination of "r", "w", "x" and "c".
+}
+
+// Returns true if a given platform name (e.g. "", "unix", "windows", "launchd") is currently applicable.
 func UsingPlatform(platformName string) bool {
 	if platformName == "" {
 		return true
@@ -149,6 +798,60 @@ func UsingPlatform(platformName string) bool {
 	return usingPlatform(platformName)
 }
 
+// Returns a human-readable description of the platforms on which the named
+// field of Config is supported, derived from its "platform" annotation, for
+// example "unix only", "windows only" or "all platforms". Returns "" if there
+// is no such field.
+func PlatformDoc(fieldName string) string {
+	f, ok := reflect.TypeOf(Config{}).FieldByName(fieldName)
+	if !ok {
+		return ""
+	}
+
+	platformName := f.Tag.Get("platform")
+	if platformName == "" {
+		return "all platforms"
+	}
+
+	return platformName + " only"
+}
+
+// Returns the features supported by the current platform and available at
+// runtime, such as:
+//
+//   - "chroot", "setuid", "fork" and "restart" on UNIX-like platforms;
+//   - "caps", "bansuid", "cgroups", "namespaces", "privatetmp" and
+//     "subreaper" on Linux;
+//   - "unveil" on OpenBSD;
+//   - "launchd" on macOS;
+//   - "windows-service" and "eventlog" on Windows;
+//   - "systemd" if the service is running under systemd.
+//
+// Features may be added in future.
+func PlatformFeatures() []string {
+	features := platformFeatures()
+	if DetectedInitSystem() == "systemd" {
+		features = append(features, "systemd")
+	}
+
+	return features
+}
+
+// Returns true if the process is running with superuser privileges: as root
+// or with capabilities on UNIX, or with an elevated token (as for SYSTEM or an
+// administrator) on Windows.
+func IsRoot() bool {
+	return isRoot()
+}
+
+// Returns true if running a service with the given configuration would drop
+// privileges, i.e. if it specifies a non-root UID to switch to. Always false
+// on Windows, where services run under the account configured when they are
+// installed.
+func WillDropPrivileges(cfg *Config) bool {
+	return willDropPrivileges(cfg)
+}
+
 // An instantiable service.
 type Info struct {
 	// Recommended. Codename for the service, e.g. "foobar"
@@ -179,31 +882,259 @@ type Info struct {
 
 	Title       string // Optional. Friendly name for the service, e.g. "Foobar Web Server"
 	Description string // Optional. Single line description for the service
+	Version     string // Optional. Version of the service, e.g. "1.2.3"
+	Build       string // Optional. Build identifier, e.g. a git commit hash
+	ExePath     string // Optional. Binary executed when the service re-executes itself, e.g. to fork. Defaults to the current binary.
 
 	AllowRoot     bool   // May the service run as root? If false, the service will refuse to run as root unless privilege dropping is set.
 	DefaultChroot string // Default path to chroot to. Use this if the service can be chrooted without consequence.
 	NoBanSuid     bool   // Set to true if the ability to execute suid binaries must be retained.
 
+	// Optional. Linux: If Config.SubReaper is set, called with the PID and
+	// state of each child process reaped.
+	OnChildExit func(pid int, state *os.ProcessState)
+
+	// Optional. UNIX: Called when dropping privileges, before chrooting, with
+	// the path of the directory to be chrooted into. This can be used to
+	// populate the chroot, for example by copying files, bind mounting
+	// directories or creating device nodes, while still running as root. Not
+	// called if no chroot is to be performed. If it returns an error, dropping
+	// privileges fails.
+	ChrootSetup func(chrootPath string) error
+
+	// Optional. UNIX: Called when the service exits with the path of the
+	// directory chrooted into, to undo ChrootSetup, for example by unmounting
+	// bind mounts and removing the directory. As the service itself cannot do
+	// this once it has chrooted and dropped privileges, a helper process is
+	// started before chrooting, running the service binary with the same
+	// arguments, which waits for the service to exit and then calls
+	// ChrootTeardown as root. It is therefore called even if the service
+	// crashes. Not called if no chroot is to be performed.
+	ChrootTeardown func(chrootPath string) error
+
 	// This must contain the configuration variables to be used to run the service. It will generally be parsed by an application from a command line.
 	Config Config
 
+	// Optional. The logger used for messages about the service lifecycle, such
+	// as the service starting and stopping and errors which cause it to exit.
+	// If nil, warnings and errors are logged using slog.Default() and
+	// informational messages are discarded.
+	Logger *slog.Logger
+
+	// Optional. A simpler alternative to Logger for applications which do
+	// not use slog. If this is set and Logger is nil, each error message is
+	// written to this writer as "<message>: <error>", or just "<message>" if
+	// there is no underlying error, followed by a newline. The error is
+	// formatted using %+v. Warnings are still logged using the
+	// default logger and informational messages are discarded. Nothing is
+	// written if Logger is set. See ServiceLogWriter for a writer which
+	// prefixes each line with a timestamp and severity level.
+	ErrorWriter io.Writer
+
+	// Optional. The backend used to carry out the service command specified in
+	// Config.Command. If nil, a backend appropriate to the current platform is
+	// chosen automatically.
+	Backend ServiceBackend
+
+	// If set, a panic in RunFunc is recovered and converted to a *PanicError,
+	// which includes a stack trace, and the service fails with that error as
+	// though RunFunc had returned it. Otherwise, a panic in RunFunc terminates
+	// the process.
+	RecoverPanic bool
+
+	// Optional. If RecoverPanic is set, called when a panic in RunFunc is
+	// recovered with the value passed to panic and the stack trace of the
+	// panicking goroutine, for example to report the panic to an error tracking
+	// service.
+	OnPanic func(recovered interface{}, stack []byte)
+
+	// Optional. If set and Config.HealthCheckInterval is non-zero, called
+	// periodically while the service is running. If it returns an error, the
+	// service status is updated to reflect the failure, and if the check fails
+	// repeatedly, Config.HealthCheckFailureAction is taken. The status is
+	// restored when the check next succeeds.
+	HealthCheck func() error
+
+	// Optional. If non-empty, a directory which is scanned for Go plugins
+	// ("*.so" files) whenever a reload signal (SIGHUP) is received. Each plugin
+	// not already loaded is opened and its exported ServicePlugin symbol, which
+	// must be a func(service.Manager), is called in a new goroutine.
+	//
+	// Go plugins have significant limitations, which should be understood
+	// before using this facility:
+	//
+	//   - Plugin support must be enabled by building the service with the
+	//     serviceplugin build tag (go build -tags serviceplugin), as it
+	//     requires cgo and dynamic linking. Otherwise, loading plugins always
+	//     fails.
+	//
+	//   - Plugins are only supported on Linux, FreeBSD and macOS, and only when
+	//     cgo is enabled. On other platforms, loading a plugin always fails.
+	//
+	//   - A plugin must be built with exactly the same toolchain, build tags
+	//     and versions of every package it shares with the service binary
+	//     (including this package), or it will fail to load.
+	//
+	//   - Plugins can never be unloaded. A plugin file is only loaded once;
+	//     replacing a plugin file and sending another reload signal has no
+	//     effect. New functionality must be shipped under a new filename.
+	//
+	// This is therefore only suitable for adding functionality to a running
+	// service, not for replacing it.
+	PluginDir string
+
+	// Optional. Called when restarting via Manager.Restart, after RunFunc has
+	// returned and before the new binary is executed. The function can write
+	// any state which should be preserved to the given file, which is a
+	// temporary file which has already been unlinked. The file is passed to
+	// the new process, where it is given to OnRestore.
+	OnSnapshot func(f *os.File) error
+
+	// Optional. Called in a process started via Manager.Restart, before
+	// RunFunc is called, with the file written by OnSnapshot, positioned at
+	// its start. The file is closed after OnRestore returns. If OnRestore
+	// returns an error, the service fails to start.
+	OnRestore func(f *os.File) error
+
+	// Optional. Called when restarting via Manager.Restart, after RunFunc has
+	// returned, to obtain files which should be passed to the new process,
+	// such as listening sockets which must remain open across the restart.
+	// The new process retrieves them, in the same order, using
+	// Manager.InheritedFiles. See also the daemon/fdpass package for passing
+	// files to an unrelated process.
+	InheritFiles func() []*os.File
+
 	// Are we being started by systemd with [Service] Type=notify?
 	// If so, we can issue service status notifications to systemd.
 	systemd bool
 
-	// Path to created PID file.
-	pidFileName string
-	pidFile     io.Closer
+	// If non-zero, the watchdog interval configured by systemd. A keepalive is
+	// sent at half this interval.
+	systemdWatchdog time.Duration
+
+	// The number of sockets passed by systemd socket activation.
+	systemdListenFDs int
+
+	// Are we being started by launchd? If so, we must not fork.
+	launchd bool
+
+	// If non-negative, a file descriptor to which a newline is written when
+	// the service has started, for s6 readiness notification.
+	s6NotifyFD int
+
+	// Paths to created PID files.
+	pidFileNames []string
+	pidFiles     []io.Closer
+
+	// The directory chrooted into when dropping privileges, if any.
+	chrootPath string
+
+	// Closed when the process exits, signalling the ChrootTeardown helper.
+	chrootTeardownPipe *os.File
+
+	// Paths of the created service directories.
+	runtimeDir string
+	stateDir   string
+	cacheDir   string
+	logDir     string
+
+	// Files passed by the process this one replaced. See InheritFiles.
+	inheritedFiles []*os.File
+
+	// Paths of plugins already loaded from PluginDir.
+	loadedPlugins map[string]struct{}
+
+	// Credentials read by loadCredentials.
+	credentials map[string][]byte
 }
 
 func (info *Info) main() {
-	err := info.maine()
+	err := info.run()
+	var se *statusError
+	if errors.As(err, &se) {
+		if se.err != nil {
+			info.logError("Error in service", err)
+		}
+		os.Exit(se.exitCode())
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error in service: %+v\n", err)
+		info.logError("Error in service", err)
 		os.Exit(1)
 	}
 }
 
+func (info *Info) run() error {
+	err := info.maine()
+	if err == errRestart {
+		err = info.execRestart()
+	}
+	if err != nil {
+		reportForkResult(err)
+	}
+	return err
+}
+
+// Returns the binary executed when the service re-executes itself.
+func (info *Info) exePath() string {
+	if info.ExePath != "" {
+		return info.ExePath
+	}
+
+	return exepath.Abs
+}
+
+// Returns Logger, or the default logger if it is not set. The default logger
+// only logs warnings and errors, so that a service which does not set Logger
+// prints nothing more than it used to.
+func (info *Info) logger() *slog.Logger {
+	if info.Logger != nil {
+		return info.Logger
+	}
+
+	return slog.New(minLevelHandler{slog.Default().Handler(), slog.LevelWarn})
+}
+
+// A slog.Handler which discards records below a minimum level.
+type minLevelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h minLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h minLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return minLevelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h minLevelHandler) WithGroup(name string) slog.Handler {
+	return minLevelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// Reports an internal error. If Logger is nil and ErrorWriter is set, the
+// error is written to ErrorWriter as a line of text; otherwise, it is logged
+// using the logger.
+func (info *Info) logError(msg string, err error) {
+	if info.Logger == nil && info.ErrorWriter != nil {
+		if err != nil {
+			fmt.Fprintf(info.ErrorWriter, "%s: %+v\n", msg, err)
+		} else {
+			fmt.Fprintf(info.ErrorWriter, "%s\n", msg)
+		}
+		return
+	}
+
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		info.logger().Error(msg, "service", info.Name, "err", err, "stack", string(panicErr.Stack))
+	} else if err != nil {
+		info.logger().Error(msg, "service", info.Name, "err", err)
+	} else {
+		info.logger().Error(msg, "service", info.Name)
+	}
+}
+
 func (info *Info) maine() error {
 	if info.Name == "" {
 		info.Name = exepath.ProgramName
@@ -222,7 +1153,19 @@ func (info *Info) maine() error {
 		info.Description = info.Title
 	}
 
-	err := info.commonPre()
+	versionVar.Set(info.Version)
+	buildVar.Set(info.Build)
+
+	err := info.validate()
+	if err != nil {
+		return err
+	}
+
+	if ran, err := info.runChrootTeardown(); ran {
+		return err
+	}
+
+	err = info.commonPre()
 	if err != nil {
 		return err
 	}
@@ -252,6 +1195,39 @@ func (info *Info) commonPre() error {
 	return nil
 }
 
+// Calls f, which is RunFunc or a part of it, recovering any panic if
+// RecoverPanic is set.
+func (info *Info) callRunFunc(f func(smgr Manager) error, smgr Manager) (err error) {
+	if info.RecoverPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				if info.OnPanic != nil {
+					info.OnPanic(r, stack)
+				}
+				err = &PanicError{Value: r, Stack: stack}
+			}
+		}()
+	}
+
+	return f(smgr)
+}
+
+// Waits for drainChan to be closed, or for ShutdownTimeout to expire.
+func (info *Info) waitDrain(drainChan <-chan struct{}) {
+	var timeoutChan <-chan time.Time
+	if info.Config.ShutdownTimeout > 0 {
+		timer := time.NewTimer(info.Config.ShutdownTimeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case <-drainChan:
+	case <-timeoutChan:
+	}
+}
+
 func (info *Info) setRunFunc() error {
 	if info.RunFunc != nil {
 		return nil
@@ -306,6 +1282,12 @@ func (info *Info) setRunFunc() error {
 			}
 		}
 
+		// drain
+		if d, ok := r.(Drainer); ok {
+			smgr.SetStatus(info.Name + ": draining")
+			info.waitDrain(d.Drain())
+		}
+
 		// stop
 		return r.Stop()
 	}
@@ -323,6 +1305,41 @@ type ihandler struct {
 	started          bool
 	stopping         bool
 	dropped          bool
+	changedIDs       bool
+	listeners        []net.Listener
+	restartChan      chan struct{}
+	restarting       bool
+	goroutines       goroutineGroup
+	healthErr        error
+	healthFailures   int
+	ready            readiness
+	readyChan        chan struct{}
+	components       componentStatuses
+	history          statusHistory
+	draining         atomic.Bool
+	drainTimerChan   <-chan time.Time
+	preDrop          preDropFiles
+}
+
+func (h *ihandler) Go(f func(ctx context.Context)) error {
+	return h.goroutines.Go(h.stopChan, f)
+}
+
+func (h *ihandler) Wait() error {
+	return h.goroutines.Wait()
+}
+
+func (h *ihandler) PreDropOpen(path string, flags int) error {
+	return h.preDrop.request(path, flags)
+}
+
+func (h *ihandler) PreDropFiles() map[string]*os.File {
+	return h.preDrop.get()
+}
+
+// Returns true if DropPrivileges has succeeded.
+func (h *ihandler) HasDropped() bool {
+	return h.dropped
 }
 
 func (h *ihandler) SetStarted() {
@@ -330,44 +1347,171 @@ func (h *ihandler) SetStarted() {
 		panic("service must call DropPrivileges before calling SetStarted")
 	}
 
+	if !h.ready.setStarted() {
+		return
+	}
+
 	select {
 	case h.startedChan <- struct{}{}:
 	default:
 	}
 }
 
+func (h *ihandler) SetReady(ready bool) {
+	if !h.ready.set(ready) {
+		return
+	}
+
+	h.info.logReadiness(ready)
+
+	select {
+	case h.readyChan <- struct{}{}:
+	default:
+	}
+}
+
+func (h *ihandler) IsReady() bool {
+	return h.ready.isReady()
+}
+
+func (h *ihandler) GetCredential(name string) ([]byte, error) {
+	return h.info.getCredential(name)
+}
+
+func (h *ihandler) SocketListeners() []net.Listener {
+	return h.listeners
+}
+
 func (h *ihandler) StopChan() <-chan struct{} {
 	return h.stopChan
 }
 
+func (h *ihandler) IsDraining() bool {
+	return h.draining.Load()
+}
+
 func (h *ihandler) SetStatus(status string) {
 	h.statusMutex.Lock()
 	h.status = status
+	h.history.add(h.info.Config.StatusHistorySize, StatusEntry{Time: time.Now(), Status: status})
+	h.statusMutex.Unlock()
+	h.notifyStatus()
+}
+
+func (h *ihandler) SetComponentStatus(component, status string) {
+	h.statusMutex.Lock()
+	h.components.set(component, status)
+	h.history.add(h.info.Config.StatusHistorySize, StatusEntry{Time: time.Now(), Status: status, Component: component})
 	h.statusMutex.Unlock()
+	h.notifyStatus()
+}
+
+func (h *ihandler) StatusHistory(n int) []StatusEntry {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+	return h.history.last(n)
+}
+
+func (h *ihandler) ClearComponentStatus(component string) {
+	h.statusMutex.Lock()
+	h.components.clear(component)
+	h.statusMutex.Unlock()
+	h.notifyStatus()
+}
+
+// Returns the overall status of the service, including component statuses.
+func (h *ihandler) fullStatus() string {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+	return h.components.join(h.status)
+}
+
+// Publishes the overall status and asks for it to be reported.
+func (h *ihandler) notifyStatus() {
+	publishStatus(h.info.Name, h.fullStatus())
 
 	select {
-	case <-h.statusNotifyChan:
+	case h.statusNotifyChan <- struct{}{}:
 	default:
 	}
 }
 
 func (h *ihandler) updateStatus() {
+	status := h.fullStatus()
+	if h.healthErr != nil {
+		status = healthCheckFailedStatus + h.healthErr.Error()
+	}
+
 	// systemd
 	if h.info.systemd {
 		s := ""
 		if h.started {
-			s += "READY=1\n"
+			if h.ready.isReady() {
+				s += "READY=1\n"
+			} else {
+				s += "READY=0\n"
+			}
 		}
-		if h.status != "" {
-			s += "STATUS=" + h.status + "\n"
+		if status != "" {
+			s += "STATUS=" + status + "\n"
 		}
 		systemdUpdateStatus(s)
 		// ignore error
 	}
 
-	if h.status != "" {
-		gsptcall.SetProcTitle(h.status)
+	gsptcall.SetProcTitle(h.info.processTitle(status))
+}
+
+// Begins stopping the service. If Config.DrainTimeout is set, the stop channel
+// is closed by the main loop once it has elapsed.
+func (h *ihandler) stop() {
+	h.info.logger().Info("stopping service", "service", h.info.Name)
+	h.stopping = true
+	h.draining.Store(true)
+
+	if d := h.info.Config.DrainTimeout; d > 0 {
+		h.drainTimerChan = time.After(d)
+	} else {
+		close(h.stopChan)
+	}
+
+	h.updateStatus()
+}
+
+// Returns the process title for the given status, which is prefixed with
+// Config.ProcessTitlePrefix unless it already begins with it.
+func (info *Info) processTitle(status string) string {
+	prefix := info.Config.ProcessTitlePrefix
+	if prefix == "" {
+		prefix = info.Name + ": "
+	}
+
+	if status == "" {
+		return strings.TrimSpace(prefix)
+	}
+
+	if strings.HasPrefix(status, prefix) {
+		return status
+	}
+
+	return prefix + status
+}
+
+// Writes the stacks of all goroutines to the named file, replacing its
+// contents.
+func dumpGoroutines(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = pprof.Lookup("goroutine").WriteTo(f, 2)
+	if err != nil {
+		f.Close()
+		return err
 	}
+
+	return f.Close()
 }
 
 func (info *Info) runInteractively() error {
@@ -376,16 +1520,56 @@ func (info *Info) runInteractively() error {
 		stopChan:         make(chan struct{}),
 		statusNotifyChan: make(chan struct{}, 1),
 		startedChan:      make(chan struct{}, 1),
+		restartChan:      make(chan struct{}, 1),
+		readyChan:        make(chan struct{}, 1),
 	}
 
+	err := info.restore()
+	if err != nil {
+		return err
+	}
+
+	healthDoneChan := make(chan struct{})
+	defer close(healthDoneChan)
+	healthChan := info.startHealthChecks(healthDoneChan)
+
 	doneChan := make(chan error)
 	go func() {
-		err := info.RunFunc(&smgr)
+		err := info.callRunFunc(info.RunFunc, &smgr)
 		doneChan <- err
 	}()
 
+	stopSignals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if len(info.Config.StopSignals) > 0 && usingPlatform("unix") {
+		stopSignals = info.Config.StopSignals
+	}
+
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, stopSignals...)
+
+	var quitSig chan os.Signal
+	if (info.Config.NoDumpGoroutinesOnQuit || info.Config.DumpGoroutinesFile != "") && len(quitSignals) > 0 {
+		quitSig = make(chan os.Signal, 1)
+		signal.Notify(quitSig, quitSignals...)
+	}
+
+	var reloadSig chan os.Signal
+	if info.PluginDir != "" && len(reloadSignals) > 0 {
+		reloadSig = make(chan os.Signal, 1)
+		signal.Notify(reloadSig, reloadSignals...)
+	}
+
+	var managementChan chan managementCmd
+	if info.Config.ManagementSocket != "" {
+		managementChan = make(chan managementCmd)
+	}
+
+	var watchdogChan <-chan time.Time
+	if info.systemd && info.systemdWatchdog > 0 {
+		ticker := time.NewTicker(info.systemdWatchdog / 2)
+		defer ticker.Stop()
+		watchdogChan = ticker.C
+	}
 
 	var exitErr error
 
@@ -394,21 +1578,74 @@ loop:
 		select {
 		case <-sig:
 			if !smgr.stopping {
+				smgr.stop()
+			} else if info.Config.ForceExitOnSecondSignal {
+				info.logError("Received second stop signal, exiting immediately", nil)
+				os.Exit(1)
+			}
+		case <-smgr.restartChan:
+			if !smgr.stopping {
+				info.logger().Info("restarting service", "service", info.Name)
 				smgr.stopping = true
+				smgr.draining.Store(true)
+				smgr.restarting = true
 				close(smgr.stopChan)
 				smgr.updateStatus()
 			}
 		case <-smgr.startedChan:
 			if !smgr.started {
 				smgr.started = true
+				info.logger().Info("service started", "service", info.Name)
+				info.notifyStarted()
 				smgr.updateStatus()
+
+				if managementChan != nil {
+					l, err := info.listenManagement(managementChan)
+					if err != nil {
+						info.logError("Error opening management socket", err)
+					} else {
+						defer l.Close()
+					}
+				}
 			}
+		case <-smgr.drainTimerChan:
+			smgr.drainTimerChan = nil
+			close(smgr.stopChan)
 		case <-smgr.statusNotifyChan:
 			smgr.updateStatus()
+		case <-smgr.readyChan:
+			if smgr.started {
+				smgr.updateStatus()
+			}
+		case <-watchdogChan:
+			systemdUpdateStatus("WATCHDOG=1\n")
+			// ignore error
+		case err := <-healthChan:
+			smgr.healthCheckResult(err)
+		case <-quitSig:
+			if info.Config.DumpGoroutinesFile != "" {
+				err := dumpGoroutines(info.Config.DumpGoroutinesFile)
+				if err != nil {
+					info.logError("Error dumping goroutines", err)
+				}
+			} else {
+				info.logger().Info("ignoring quit signal", "service", info.Name)
+			}
+		case cmd := <-managementChan:
+			cmd.respChan <- smgr.managementCommand(cmd.req)
+		case <-reloadSig:
+			err := info.loadPlugins(&smgr)
+			if err != nil {
+				info.logError("Error loading plugins", err)
+			}
 		case exitErr = <-doneChan:
 			break loop
 		}
 	}
 
+	if exitErr == nil && smgr.restarting {
+		return errRestart
+	}
+
 	return exitErr
 }
@@ -0,0 +1,11 @@
+package service
+
+import "os"
+
+// Returns true if the process appears to have been started by launchd as the
+// job with the given label. launchd sets XPC_SERVICE_NAME to the label of the
+// job it starts; processes started directly by launchd also have it as their
+// parent.
+func underLaunchd(label string) bool {
+	return os.Getenv("XPC_SERVICE_NAME") == label || os.Getppid() == 1
+}
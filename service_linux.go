@@ -0,0 +1,575 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"gopkg.in/hlandau/service.v3/daemon"
+	"gopkg.in/hlandau/service.v3/daemon/apparmor"
+	"gopkg.in/hlandau/service.v3/daemon/selinux"
+)
+
+// Features reported by PlatformFeatures on Linux.
+var linuxFeatures = []string{"caps", "bansuid", "cgroups", "namespaces", "privatetmp", "subreaper"}
+
+// The mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// The period used when writing cpu.max, in microseconds.
+const cgroupCPUPeriod = 100000
+
+// Applies the configured cgroup limits and moves the process into the
+// configured cgroup.
+func (info *Info) joinCgroup() error {
+	if info.Config.CgroupPath == "" {
+		return nil
+	}
+
+	path, err := cgroupDir(info.Config.CgroupPath)
+	if err != nil {
+		return err
+	}
+
+	if info.Config.CgroupMemoryMax > 0 {
+		err = writeCgroupFile(path, "memory.max", strconv.FormatInt(info.Config.CgroupMemoryMax, 10))
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.Config.CgroupCPUQuota > 0 {
+		quota := int64(info.Config.CgroupCPUQuota) * cgroupCPUPeriod / 100
+		err = writeCgroupFile(path, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod))
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeCgroupFile(path, "cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// Returns the directory of the cgroup with the given path, which may be
+// relative to cgroupRoot. The directory must be within cgroupRoot.
+func cgroupDir(path string) (string, error) {
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("cgroup path must not contain \"..\": %q", path)
+		}
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cgroupRoot, path)
+	}
+
+	path = filepath.Clean(path)
+	if path != cgroupRoot && !strings.HasPrefix(path, cgroupRoot+"/") {
+		return "", fmt.Errorf("cgroup path must be within %s: %q", cgroupRoot, path)
+	}
+
+	return path, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	fn := filepath.Join(dir, name)
+	err := os.WriteFile(fn, []byte(value+"\n"), 0)
+	if err != nil {
+		return fmt.Errorf("cannot write cgroup file %q: %v", fn, err)
+	}
+
+	return nil
+}
+
+// Returns the name of the parent process as reported by /proc, or "" if it
+// cannot be determined.
+func parentProcessName() string {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(os.Getppid()), "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// Returns the system-wide maximum number of open files, from
+// /proc/sys/fs/file-max, or 0 if it cannot be determined.
+func getDefaultMaxOpenFiles() uint64 {
+	b, err := os.ReadFile("/proc/sys/fs/file-max")
+	if err != nil {
+		return 0
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// If running as root, sets the system-wide core pattern so that core dumps are
+// written to dir.
+func setCoreDumpDir(dir string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	pattern := filepath.Join(dir, "core.%e.%p")
+	err := os.WriteFile("/proc/sys/kernel/core_pattern", []byte(pattern+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot set core pattern: %v", err)
+	}
+
+	return nil
+}
+
+var namespaceTypes = map[string]int{
+	"net": unix.CLONE_NEWNET,
+	"mnt": unix.CLONE_NEWNS,
+	"uts": unix.CLONE_NEWUTS,
+	"ipc": unix.CLONE_NEWIPC,
+	"pid": unix.CLONE_NEWPID,
+}
+
+// Enters the namespaces listed in Config.Namespaces, if this has not already
+// been done. As setns(2) only affects the calling thread, the namespaces are
+// entered on a locked thread which then re-executes the service binary, so
+// that the whole new process image runs in them. Does not return if
+// successful.
+func (info *Info) enterNamespaces() error {
+	if len(info.Config.Namespaces) == 0 || seenMarkers[namespacesEnv] {
+		return nil
+	}
+
+	// The thread is left locked on failure, as it may be in some of the
+	// namespaces, so that it is discarded rather than reused.
+	runtime.LockOSThread()
+
+	for _, ns := range info.Config.Namespaces {
+		nstype, ok := namespaceTypes[ns.Type]
+		if !ok {
+			return fmt.Errorf("unknown namespace type: %q", ns.Type)
+		}
+
+		f, err := os.Open(ns.Path)
+		if err != nil {
+			return err
+		}
+
+		err = unix.Setns(int(f.Fd()), nstype)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot enter %s namespace %q: %v", ns.Type, ns.Path, err)
+		}
+	}
+
+	return info.reexec(namespacesEnv)
+}
+
+// Re-executes the service binary with the given marker set in its
+// environment, along with any markers set when this process was started.
+func (info *Info) reexec(marker string) error {
+	env := os.Environ()
+	for _, name := range reexecMarkers {
+		if seenMarkers[name] || name == marker {
+			env = append(env, name+"=1")
+		}
+	}
+
+	return syscall.Exec(info.exePath(), os.Args, env)
+}
+
+// Transitions to Config.AppArmorProfile, if this has not already been done,
+// by setting the profile to be applied on exec and re-executing the service
+// binary on the same thread. Does not return if successful.
+func (info *Info) enterAppArmorProfile() error {
+	profile := info.Config.AppArmorProfile
+	if profile == "" || seenMarkers[appArmorEnv] {
+		return nil
+	}
+
+	if !apparmor.Enabled() {
+		info.logger().Info("AppArmor is not enabled, ignoring profile", "service", info.Name, "profile", profile)
+		return nil
+	}
+
+	// The profile applies on exec by this thread, so it is left locked on
+	// failure rather than reused.
+	runtime.LockOSThread()
+
+	err := apparmor.SetExecProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	return info.reexec(appArmorEnv)
+}
+
+// Transitions to Config.SELinuxContext, if this has not already been done, in
+// the same way as enterAppArmorProfile. Does not return if successful.
+func (info *Info) enterSELinuxContext() error {
+	context := info.Config.SELinuxContext
+	if context == "" || seenMarkers[selinuxEnv] {
+		return nil
+	}
+
+	if !selinux.Enabled() {
+		info.logger().Info("SELinux is not enabled, ignoring context", "service", info.Name, "context", context)
+		return nil
+	}
+
+	// As for enterAppArmorProfile, the thread is left locked on failure.
+	runtime.LockOSThread()
+
+	err := selinux.SetExecContext(context)
+	if err != nil {
+		return err
+	}
+
+	return info.reexec(selinuxEnv)
+}
+
+var unshareTypes = map[string]uintptr{
+	"net":  unix.CLONE_NEWNET,
+	"mnt":  unix.CLONE_NEWNS,
+	"uts":  unix.CLONE_NEWUTS,
+	"ipc":  unix.CLONE_NEWIPC,
+	"pid":  unix.CLONE_NEWPID,
+	"user": unix.CLONE_NEWUSER,
+}
+
+func (info *Info) unshareFlags() (uintptr, error) {
+	var flags uintptr
+	for _, name := range info.Config.UnshareNamespaces {
+		flag, ok := unshareTypes[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown namespace type: %q", name)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+// Returns the attributes with which the child is started when forking, so
+// that it is created in the namespaces listed in Config.UnshareNamespaces.
+func (info *Info) forkSysProcAttr() (*syscall.SysProcAttr, error) {
+	flags, err := info.unshareFlags()
+	if err != nil || flags == 0 {
+		return nil, err
+	}
+
+	sys := &syscall.SysProcAttr{Cloneflags: flags}
+	if flags&unix.CLONE_NEWUSER != 0 {
+		uid, gid, err := info.serviceIDs()
+		if err != nil {
+			return nil, err
+		}
+
+		if uid <= 0 {
+			uid, gid = os.Geteuid(), os.Getegid()
+		}
+
+		// Map the service user to the current user, so that the service runs
+		// as its configured user inside the namespace without requiring root
+		// outside it.
+		sys.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: os.Geteuid(), Size: 1}}
+		sys.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: os.Getegid(), Size: 1}}
+	}
+
+	return sys, nil
+}
+
+// Unshares the namespaces listed in Config.UnshareNamespaces when not forking.
+// As with enterNamespaces, this is done on a locked thread which then
+// re-executes the service binary. Does not return if successful.
+func (info *Info) unshareNamespaces() error {
+	flags, err := info.unshareFlags()
+	if err != nil || flags == 0 || seenMarkers[unsharedEnv] {
+		return err
+	}
+
+	if flags&unix.CLONE_NEWUSER != 0 {
+		return fmt.Errorf("unsharing a user namespace requires Fork")
+	}
+
+	// As for enterNamespaces, the thread is left locked on failure.
+	runtime.LockOSThread()
+
+	err = unix.Unshare(int(flags))
+	if err != nil {
+		return fmt.Errorf("cannot unshare namespaces: %v", err)
+	}
+
+	return info.reexec(unsharedEnv)
+}
+
+// Starts a goroutine which reaps child processes as they exit, calling
+// OnChildExit for each. Children started with StartCommand are left for the
+// service to wait for.
+func (info *Info) reapChildren() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGCHLD)
+
+	go func() {
+		for range sig {
+			for _, pid := range exitedChildren() {
+				// Waiting via os.Process both reaps the child and provides a
+				// ProcessState.
+				p, err := os.FindProcess(pid)
+				if err != nil {
+					continue
+				}
+
+				state, err := p.Wait()
+				if err != nil {
+					continue
+				}
+
+				if info.OnChildExit != nil {
+					info.OnChildExit(pid, state)
+				}
+			}
+		}
+	}()
+}
+
+// Returns the PIDs of the children which have exited, other than those started
+// with StartCommand, without reaping them.
+func exitedChildren() []int {
+	childMutex.Lock()
+	defer childMutex.Unlock()
+
+	children := childPIDs()
+	isChild := map[int]bool{}
+	var exited []int
+	for _, pid := range children {
+		isChild[pid] = true
+		if ownChildren[pid] {
+			continue
+		}
+
+		// si_signo is left zero if the child has not exited.
+		var si unix.Siginfo
+		err := unix.Waitid(unix.P_PID, pid, &si, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil)
+		if err == nil && si.Signo != 0 {
+			exited = append(exited, pid)
+		}
+	}
+
+	// Forget children started with StartCommand once they have been waited
+	// for, as their PIDs may be reused.
+	for pid := range ownChildren {
+		if !isChild[pid] {
+			delete(ownChildren, pid)
+		}
+	}
+
+	return exited
+}
+
+// Returns the PIDs of the children of this process, including those which have
+// exited but have not yet been reaped.
+func childPIDs() []int {
+	var pids []int
+	fns, _ := filepath.Glob("/proc/self/task/*/children")
+	for _, fn := range fns {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range strings.Fields(string(b)) {
+			pid, err := strconv.Atoi(f)
+			if err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	return pids
+}
+
+func (info *Info) setSubreaper() error {
+	if !info.Config.SubReaper {
+		return nil
+	}
+
+	err := daemon.SetSubreaper()
+	if err != nil {
+		return fmt.Errorf("cannot become subreaper: %v", err)
+	}
+
+	info.reapChildren()
+	return nil
+}
+
+// Creates the bind mounts configured by Config.ChrootBindMounts.
+func (info *Info) bindMounts(chrootPath string) error {
+	// Copied so that appending the devices cannot modify the caller's array.
+	mounts := append([]BindMount(nil), info.Config.ChrootBindMounts...)
+	for _, dev := range info.Config.ChrootDevices {
+		mounts = append(mounts, BindMount{Source: dev, Target: dev})
+	}
+
+	root, err := filepath.EvalSymlinks(chrootPath)
+	if err != nil {
+		return err
+	}
+
+	mounted, err := mountPoints()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		target, err := mountTarget(root, m.Target)
+		if err != nil {
+			return err
+		}
+
+		// The chroot may be reused, for example when the service is restarted.
+		if mounted[target] {
+			continue
+		}
+
+		err = createMountTarget(m.Source, target)
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Mount(m.Source, target, "", syscall.MS_BIND, "")
+		if err != nil {
+			return fmt.Errorf("cannot bind mount %q at %q: %v", m.Source, target, err)
+		}
+
+		// The read-only flag is ignored when the bind mount is first created,
+		// so it must be applied by remounting.
+		if m.ReadOnly {
+			err = syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+			if err != nil {
+				return fmt.Errorf("cannot make bind mount at %q read-only: %v", target, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the path at which to mount target, which is relative to root. The
+// target must not contain ".." or any symbolic links within root, so that a
+// bind mount cannot be redirected outside the chroot.
+func mountTarget(root, target string) (string, error) {
+	path := root
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("bind mount target must not contain \"..\": %q", target)
+		}
+
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			// The rest of the path is created by createMountTarget.
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("bind mount target must not contain symbolic links: %q", path)
+		}
+	}
+
+	if path == root {
+		return "", fmt.Errorf("bind mount target must not be the chroot itself: %q", target)
+	}
+
+	return path, nil
+}
+
+// Returns the set of paths at which something is mounted, from
+// /proc/self/mountinfo.
+func mountPoints() (map[string]bool, error) {
+	b, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	points := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+
+		points[unescapeMountInfo(fields[4])] = true
+	}
+
+	return points, nil
+}
+
+// Decodes the octal escapes, such as "\040" for a space, used in
+// /proc/self/mountinfo.
+func unescapeMountInfo(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// Creates a directory, or an empty file if source is a file, at target unless
+// it already exists.
+func createMountTarget(source, target string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	if fi.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// Takes an exclusive lock on the whole of the PID file f, failing if another
+// process holds a lock on it. This is an open file description lock, so a child
+// which inherits f shares it and it is held until the last descriptor for the
+// file is closed; it conflicts with the record lock taken by pidfile.Open.
+func lockPIDFile(f *os.File) error {
+	return unix.FcntlFlock(f.Fd(), unix.F_OFD_SETLK, &unix.Flock_t{
+		Type: unix.F_WRLCK,
+	})
+}
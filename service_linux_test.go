@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountTarget(t *testing.T) {
+	root := t.TempDir()
+	err := os.Mkdir(filepath.Join(root, "dev"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink("/etc", filepath.Join(root, "etc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"/dev/null", filepath.Join(root, "dev/null")},
+		{"dev/./null", filepath.Join(root, "dev/null")},
+		{"/new/dir", filepath.Join(root, "new/dir")},
+		{"/etc/passwd", ""},
+		{"/dev/../../etc", ""},
+		{"/", ""},
+	}
+
+	for _, tt := range tests {
+		path, err := mountTarget(root, tt.target)
+		if tt.path == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tt.target, path)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tt.target, err)
+		} else if path != tt.path {
+			t.Errorf("%q: got %q, expected %q", tt.target, path, tt.path)
+		}
+	}
+}
+
+func TestUnescapeMountInfo(t *testing.T) {
+	s := unescapeMountInfo(`/mnt/a\040b\134c`)
+	if s != `/mnt/a b\c` {
+		t.Fatalf("got %q", s)
+	}
+}
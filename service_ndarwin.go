@@ -0,0 +1,8 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package service
+
+func underLaunchd(label string) bool {
+	return false
+}
@@ -0,0 +1,92 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var linuxFeatures []string
+
+func (info *Info) joinCgroup() error {
+	if info.Config.CgroupPath != "" {
+		return fmt.Errorf("cgroups are only supported on Linux")
+	}
+
+	return nil
+}
+
+func (info *Info) enterNamespaces() error {
+	if len(info.Config.Namespaces) > 0 {
+		return fmt.Errorf("namespaces are only supported on Linux")
+	}
+
+	return nil
+}
+
+func (info *Info) enterAppArmorProfile() error {
+	if info.Config.AppArmorProfile != "" {
+		return fmt.Errorf("AppArmor is only supported on Linux")
+	}
+
+	return nil
+}
+
+func (info *Info) enterSELinuxContext() error {
+	if info.Config.SELinuxContext != "" {
+		return fmt.Errorf("SELinux is only supported on Linux")
+	}
+
+	return nil
+}
+
+func (info *Info) forkSysProcAttr() (*syscall.SysProcAttr, error) {
+	if len(info.Config.UnshareNamespaces) > 0 {
+		return nil, fmt.Errorf("namespaces are only supported on Linux")
+	}
+
+	return nil, nil
+}
+
+func (info *Info) unshareNamespaces() error {
+	return nil
+}
+
+func (info *Info) setSubreaper() error {
+	if info.Config.SubReaper {
+		return fmt.Errorf("subreaping is only supported on Linux")
+	}
+
+	return nil
+}
+
+func (info *Info) bindMounts(chrootPath string) error {
+	if len(info.Config.ChrootBindMounts) > 0 || len(info.Config.ChrootDevices) > 0 {
+		return fmt.Errorf("bind mounts are only supported on Linux")
+	}
+
+	return nil
+}
+
+func setCoreDumpDir(dir string) error {
+	return fmt.Errorf("setting the core dump directory is only supported on Linux")
+}
+
+func parentProcessName() string {
+	return ""
+}
+
+func getDefaultMaxOpenFiles() uint64 {
+	return 0
+}
+
+// Takes an exclusive lock on the PID file f, failing if another process holds
+// a lock on it. A flock lock belongs to the open file, so a child which
+// inherits f shares it; on the BSDs and macOS it conflicts with the record
+// lock taken by pidfile.Open.
+func lockPIDFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
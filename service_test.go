@@ -1,6 +1,12 @@
 package service_test
 
-import "gopkg.in/hlandau/service.v3"
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/servicetest"
+)
 
 // The following example illustrates the minimal skeleton structure to
 // implement a daemon. This example can run as a service on Windows or a daemon
@@ -53,3 +59,43 @@ func Example() {
 		},
 	})
 }
+
+// A service implemented using NewFunc.
+type foobarRunnable struct {
+	stopped bool
+}
+
+func (r *foobarRunnable) Start() error {
+	return nil
+}
+
+func (r *foobarRunnable) Stop() error {
+	r.stopped = true
+	return nil
+}
+
+// The following test illustrates how to test a service implemented using
+// NewFunc with servicetest.Run.
+func TestNewFunc(t *testing.T) {
+	r := &foobarRunnable{}
+	run := servicetest.Run(t, &service.Info{
+		Name: "foobar",
+		NewFunc: func() (service.Runnable, error) {
+			return r, nil
+		},
+	}, 5*time.Second)
+
+	if s := run.LastStatus(); s != "foobar: running ok" {
+		t.Fatalf("unexpected status: %q", s)
+	}
+
+	run.Stop()
+	err := run.WaitStop(5 * time.Second)
+	if err != nil {
+		t.Fatalf("service failed: %v", err)
+	}
+
+	if !r.stopped {
+		t.Fatalf("runnable was not stopped")
+	}
+}
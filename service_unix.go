@@ -5,11 +5,24 @@ package service
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/hlandau/service.v3/daemon"
 	"gopkg.in/hlandau/service.v3/daemon/bansuid"
+	"gopkg.in/hlandau/service.v3/daemon/launchd"
+	"gopkg.in/hlandau/service.v3/daemon/privatetmp"
+	"gopkg.in/hlandau/service.v3/daemon/unveil"
+	"gopkg.in/hlandau/service.v3/internal/peercred"
 	"gopkg.in/hlandau/svcutils.v1/caps"
 	"gopkg.in/hlandau/svcutils.v1/passwd"
 	"gopkg.in/hlandau/svcutils.v1/pidfile"
@@ -24,17 +37,474 @@ import (
 // points to that.
 var EmptyChrootPath = daemon.EmptyChrootPath
 
+// The default value of Config.ForkTimeout.
+const defaultForkTimeout = 30 * time.Second
+
+// Environment variables kept when Config.ClearEnv is set.
+var keptEnvVars = append([]string{"PATH", "HOME", "TMPDIR"}, daemon.ForkEnvVars...)
+
+// Signals which cause plugins to be loaded from Info.PluginDir.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// Signals handled by Config.NoDumpGoroutinesOnQuit and
+// Config.DumpGoroutinesFile.
+var quitSignals = []os.Signal{syscall.SIGQUIT}
+
+// The platform on which the service is running: "unix" or "windows".
+const CurrentPlatform = "unix"
+
+func platformFeatures() []string {
+	features := []string{"chroot", "setuid", "fork", "restart"}
+	features = append(features, linuxFeatures...)
+	switch runtime.GOOS {
+	case "openbsd":
+		features = append(features, "unveil")
+	case "darwin":
+		features = append(features, "launchd")
+	}
+
+	return features
+}
+
 func usingPlatform(platformName string) bool {
-	return platformName == "unix"
+	switch platformName {
+	case "unix", runtime.GOOS:
+		return true
+	case "launchd":
+		return runtime.GOOS == "darwin"
+	default:
+		return false
+	}
 }
 
+// Manager.Restart is supported.
+const canRestart = true
+
 func systemdUpdateStatus(status string) error {
 	return systemd.NotifySend(status)
 }
 
+// If this process was started by daemon.Fork, tells the waiting parent whether
+// the service started successfully.
+func reportForkResult(err error) {
+	daemon.ReportForkResult(err)
+}
+
+// Returns the backend used by Config.AutoInstall and whether the service is
+// already installed. Returns ok=false if the service should not offer to
+// install itself, for example because it is already running under systemd.
+func (info *Info) autoInstallBackend() (b ServiceBackend, installed, ok bool) {
+	if os.Getenv("INVOCATION_ID") != "" || os.Getenv("NOTIFY_SOCKET") != "" {
+		return nil, false, false
+	}
+
+	// The service binary re-executing itself must not ask again.
+	if daemon.IsForkedChild() || isRestarted() || isReexeced() {
+		return nil, false, false
+	}
+
+	if DetectedInitSystem() != "systemd" {
+		return nil, false, false
+	}
+
+	sb, isSystemd := info.Backend.(*SystemdBackend)
+	if !isSystemd {
+		sb = &SystemdBackend{}
+	}
+
+	_, err := os.Stat(sb.unitPath(info))
+	return sb, err == nil, true
+}
+
+func (b *WindowsBackend) Install(info *Info) error {
+	return errNotSupported
+}
+
+func (b *WindowsBackend) Remove(info *Info) error {
+	return errNotSupported
+}
+
+func (b *WindowsBackend) Update(info *Info) error {
+	return errNotSupported
+}
+
+func (b *WindowsBackend) Start(info *Info) error {
+	return errNotSupported
+}
+
+func (b *WindowsBackend) Stop(info *Info) error {
+	return errNotSupported
+}
+
+func (b *WindowsBackend) Status(info *Info) (ServiceState, error) {
+	return StateUnknown, errNotSupported
+}
+
+func defaultBackend() (ServiceBackend, error) {
+	switch initSystem := DetectedInitSystem(); initSystem {
+	case "launchd":
+		return &LaunchdBackend{}, nil
+	case "systemd":
+		return &SystemdBackend{}, nil
+	case "openrc":
+		return &OpenRCBackend{}, nil
+	case "unknown":
+		return nil, fmt.Errorf("cannot determine service manager in use")
+	default:
+		return nil, fmt.Errorf("service manager not supported: %s", initSystem)
+	}
+}
+
+func detectInitSystem() string {
+	if runtime.GOOS == "darwin" {
+		return "launchd"
+	}
+
+	if s := supervisorParent(); s != "" {
+		return s
+	}
+
+	switch {
+	case isDir("/run/systemd/system") || exists("/run/systemd/private"):
+		return "systemd"
+	case os.Getenv("UPSTART_JOB") != "" || os.Getenv("UPSTART_EVENTS") != "":
+		return "upstart"
+	case isDir("/run/openrc"):
+		return "openrc"
+	case isDir("/run/runit"):
+		return "runit"
+	case isDir("/run/s6"):
+		return "s6"
+	case exists("/sbin/initctl") && isDir("/etc/init"):
+		return "upstart"
+	case exists("/etc/inittab"):
+		return "sysvinit"
+	default:
+		return "unknown"
+	}
+}
+
+// Returns "runit", "s6" or "supervisord" if the parent process is the
+// supervisor process of one of those systems, otherwise "".
+func supervisorParent() string {
+	name := parentProcessName()
+	switch {
+	case name == "runsv":
+		return "runit"
+	case name == "s6-supervise":
+		return "s6"
+	case strings.Contains(name, "supervisord"):
+		// supervisord is a Python script, so its name may vary.
+		return "supervisord"
+	default:
+		return ""
+	}
+}
+
+// If ChrootTeardown is set, starts the helper process which calls it once this
+// process has exited. The helper holds the read end of a pipe, whose write end
+// is closed when this process exits.
+func (info *Info) startChrootTeardown(chrootPath string) error {
+	if info.ChrootTeardown == nil || info.chrootTeardownPipe != nil {
+		return nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(info.exePath(), os.Args[1:]...)
+	cmd.Env = append(os.Environ(), chrootTeardownEnv+"="+chrootPath)
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	cmd.Process.Release()
+	info.chrootTeardownPipe = w
+	return nil
+}
+
+// If this process is the helper started by startChrootTeardown, waits for the
+// service to exit, calls ChrootTeardown and returns true.
+func (info *Info) runChrootTeardown() (bool, error) {
+	chrootPath := os.Getenv(chrootTeardownEnv)
+	if chrootPath == "" {
+		return false, nil
+	}
+
+	// Signals sent to the process group of the service must not stop the
+	// helper before it has done its job.
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	pipe := os.NewFile(3, "chroot-teardown")
+	io.Copy(io.Discard, pipe)
+	pipe.Close()
+
+	if info.ChrootTeardown == nil {
+		return true, nil
+	}
+
+	err := info.ChrootTeardown(chrootPath)
+	if err != nil {
+		return true, fmt.Errorf("chroot teardown failed: %v", err)
+	}
+
+	return true, nil
+}
+
+func isRoot() bool {
+	return daemon.IsRoot()
+}
+
+func willDropPrivileges(cfg *Config) bool {
+	if cfg.UID == "" {
+		return false
+	}
+
+	uid, err := passwd.ParseUID(cfg.UID)
+	return err == nil && uid > 0
+}
+
+func isContainer() bool {
+	if exists("/.dockerenv") || exists("/run/.containerenv") {
+		return true
+	}
+
+	// Set by systemd-nspawn, LXC and Podman, among others.
+	if os.Getenv("container") != "" || os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+
+	// Without a cgroup namespace, the cgroup of init names the container.
+	b, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+
+	for _, s := range []string{"/docker", "/kubepods", "/containerd", "/lxc/", "/libpod"} {
+		if strings.Contains(string(b), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Called when the service has started to notify any parent process or
+// supervisor.
+func (info *Info) notifyStarted() {
+	reportForkResult(nil)
+
+	if info.s6NotifyFD >= 0 {
+		syscall.Write(info.s6NotifyFD, []byte("\n"))
+		syscall.Close(info.s6NotifyFD)
+		info.s6NotifyFD = -1
+	}
+}
+
+// Determines the s6 readiness notification file descriptor, if any, from
+// the S6_NOTIFY_FD environment variable.
+func s6NotifyFD() int {
+	fd, err := strconv.Atoi(os.Getenv("S6_NOTIFY_FD"))
+	if err != nil || fd < 0 {
+		return -1
+	}
+
+	syscall.CloseOnExec(fd)
+	return fd
+}
+
+// Replaces the process with a new instance of the service binary, after
+// writing any snapshot using OnSnapshot.
+func (info *Info) execRestart() error {
+	env := restartEnviron()
+
+	if info.OnSnapshot != nil {
+		f, err := os.CreateTemp("", info.Name+"-snapshot-")
+		if err != nil {
+			return fmt.Errorf("cannot create snapshot file: %v", err)
+		}
+		defer f.Close()
+
+		os.Remove(f.Name())
+
+		err = info.OnSnapshot(f)
+		if err != nil {
+			return fmt.Errorf("cannot snapshot state: %v", err)
+		}
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		// Unlike the original, the duplicate is inherited across exec.
+		fd, err := syscall.Dup(int(f.Fd()))
+		if err != nil {
+			return err
+		}
+
+		env = append(env, restoreFDEnv+"="+strconv.Itoa(fd))
+	}
+
+	if info.InheritFiles != nil {
+		var fds []string
+		for _, f := range info.InheritFiles() {
+			fd, err := syscall.Dup(int(f.Fd()))
+			if err != nil {
+				return err
+			}
+
+			fds = append(fds, strconv.Itoa(fd))
+		}
+
+		if len(fds) > 0 {
+			env = append(env, inheritedFDsEnv+"="+strings.Join(fds, ","))
+		}
+	}
+
+	return syscall.Exec(info.exePath(), os.Args, env)
+}
+
+// Wraps a file descriptor inherited from a previous process, ensuring it is
+// not inherited further.
+func inheritFD(fd int) *os.File {
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "inherited-"+strconv.Itoa(fd))
+}
+
 func (info *Info) serviceMain() error {
+	takeReexecMarkers()
+
+	if ran, err := info.runCommand(); ran {
+		return err
+	}
+
+	if installed, err := info.autoInstall(); installed {
+		return err
+	}
+
+	info.prepareEnv()
+
+	// Namespaces and security contexts are inherited by a forked child and
+	// kept across a restart.
+	var err error
+	if !daemon.IsForkedChild() && !isRestarted() {
+		err = info.enterNamespaces()
+		if err != nil {
+			return err
+		}
+
+		err = info.enterAppArmorProfile()
+		if err != nil {
+			return err
+		}
+
+		err = info.enterSELinuxContext()
+		if err != nil {
+			return err
+		}
+	}
+
+	info.s6NotifyFD = s6NotifyFD()
+
+	// Supervisors expect the service to remain in the foreground and do
+	// nothing which might confuse them.
+	// runsv, s6-supervise and supervisord are recognised automatically.
+	parent := supervisorParent()
+	if parent == "supervisord" {
+		info.Config.SupervisordMode = true
+	}
+	supervised := info.Config.SupervisedMode || info.Config.SupervisordMode || parent != ""
+
+	// The container runtime in a container also expects the service to remain
+	// in the foreground, but the service is otherwise initialised as usual.
+	container := false
+	if !supervised && !info.Config.ForceDaemon && IsContainer() {
+		if info.Config.Daemon || info.Config.Fork {
+			info.logger().Warn("running in a container, not daemonizing; set ForceDaemon to override", "service", info.Name)
+		}
+		container = true
+	}
+
+	if supervised || container {
+		info.Config.Fork = false
+		info.Config.Daemon = false
+	}
+
+	// A restarted process keeps the PID of the process it replaced, which has
+	// already forked if necessary.
+	if isRestarted() {
+		info.Config.Fork = false
+	}
+
+	// launchd expects the processes it starts to remain in the foreground.
+	if !daemon.IsForkedChild() && underLaunchd(info.Name) {
+		info.launchd = true
+		info.Config.Fork = false
+	}
+
 	if info.Config.Fork {
-		isParent, err := daemon.Fork()
+		var pidFile *os.File
+		if info.Config.PIDFileParent && info.Config.PIDFile != "" && !daemon.IsForkedChild() {
+			// The lock taken by pidfile.Open belongs to this process and would be
+			// lost when it exits, so the file is locked with lockPIDFile instead,
+			// which the child shares by inheriting the file.
+			f, err := openParentPIDFile(info.Config.PIDFile)
+			if err != nil {
+				return err
+			}
+
+			pidFile = f
+		}
+
+		timeout := info.Config.ForkTimeout
+		if timeout == 0 {
+			timeout = defaultForkTimeout
+		}
+
+		sys, err := info.forkSysProcAttr()
+		if err != nil {
+			return err
+		}
+
+		// Pass on any sockets from systemd socket activation at the same file
+		// descriptors, so that the child can use them; see detectSystemd.
+		var extraFiles []*os.File
+		if !daemon.IsForkedChild() {
+			for i := 0; i < systemdListenFDCount(); i++ {
+				fd := systemdListenFDsStart + i
+				extraFiles = append(extraFiles, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+			}
+		}
+
+		if pidFile != nil {
+			os.Setenv(pidFileFDEnv, strconv.Itoa(systemdListenFDsStart+len(extraFiles)))
+			extraFiles = append(extraFiles, pidFile)
+		}
+
+		isParent, err := daemon.Fork(&daemon.ForkOptions{
+			ExtraFiles: extraFiles,
+			Timeout:    timeout,
+			Sys:        sys,
+			ExePath:    info.ExePath,
+		})
 		if err != nil {
 			return err
 		}
@@ -46,23 +516,62 @@ func (info *Info) serviceMain() error {
 		info.Config.Daemon = true
 	}
 
-	err := daemon.Init()
-	if err != nil {
-		return err
+	if !supervised {
+		err := daemon.Init()
+		if err != nil {
+			return err
+		}
 	}
 
-	err = systemdUpdateStatus("\n")
-	if err == nil {
-		info.systemd = true
+	if info.Config.ThreadName != "" {
+		err := daemon.SetThreadName(info.Config.ThreadName)
+		if err != nil && err != daemon.ErrNotSupported {
+			return fmt.Errorf("cannot set thread name: %v", err)
+		}
+	}
+
+	if info.Config.LockMemory {
+		err := daemon.LockMemory()
+		if err != nil {
+			return err
+		}
 	}
 
+	if info.Config.MaxOpenFiles != 0 {
+		n := info.Config.MaxOpenFiles
+		if max := getDefaultMaxOpenFiles(); max != 0 && n > max {
+			n = max
+		}
+
+		err := daemon.SetMaxOpenFiles(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.Config.CoreDumpSize != 0 {
+		err := daemon.SetCoreDumpSize(info.Config.CoreDumpSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.Config.CoreDumpDir != "" {
+		err := setCoreDumpDir(info.Config.CoreDumpDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	info.detectSystemd()
+
 	// default:                   daemon=no,  stderr=yes
 	// --daemon:                  daemon=yes, stderr=no
 	// systemd/--daemon --stderr: daemon=yes, stderr=yes
 	// systemd --daemon:          daemon=yes, stderr=no
 	daemonize := info.Config.Daemon
 	keepStderr := info.Config.Stderr
-	if !daemonize && info.systemd {
+	if !daemonize && info.systemd && !supervised && !container {
 		daemonize = true
 		keepStderr = true
 	}
@@ -74,9 +583,52 @@ func (info *Info) serviceMain() error {
 		}
 	}
 
-	if info.Config.PIDFile != "" {
-		info.pidFileName = info.Config.PIDFile
+	// When forking, namespaces are unshared by the fork itself.
+	if !daemon.IsForkedChild() && !isRestarted() {
+		err = info.unshareNamespaces()
+		if err != nil {
+			return err
+		}
+	}
+
+	// A restarted process keeps the mounts of the process it replaced.
+	if info.Config.PrivateTmp && !isRestarted() {
+		err = privatetmp.Setup()
+		if err != nil {
+			return fmt.Errorf("cannot set up private /tmp: %v", err)
+		}
+	}
+
+	err = info.setSubreaper()
+	if err != nil {
+		return err
+	}
 
+	err = info.joinCgroup()
+	if err != nil {
+		return err
+	}
+
+	if !supervised && !container {
+		pidFile, err := inheritedPIDFile()
+		if err != nil {
+			return err
+		}
+
+		if pidFile != nil {
+			info.pidFiles = append(info.pidFiles, pidFile)
+		} else if info.Config.PIDFile != "" {
+			info.pidFileNames = append(info.pidFileNames, info.Config.PIDFile)
+		}
+		info.pidFileNames = append(info.pidFileNames, info.Config.ExtraPIDFiles...)
+	}
+
+	err = info.createServiceDirs()
+	if err != nil {
+		return err
+	}
+
+	if len(info.pidFileNames) > 0 || len(info.pidFiles) > 0 {
 		err = info.openPIDFile()
 		if err != nil {
 			return err
@@ -85,19 +637,157 @@ func (info *Info) serviceMain() error {
 		defer info.closePIDFile()
 	}
 
+	// Check that the notify socket is actually usable.
+	msg := "\n"
+	if info.Version != "" {
+		msg = "STATUS=" + info.Name + " starting, version " + info.Version + "\n"
+	}
+	if info.systemd && systemdUpdateStatus(msg) != nil {
+		info.systemd = false
+	}
+
 	return info.runInteractively()
 }
 
+// The file descriptor of the first socket passed by systemd socket activation.
+const systemdListenFDsStart = 3
+
+// Detects whether the service was started by systemd from the environment
+// variables systemd sets.
+func (info *Info) detectSystemd() {
+	info.systemd = os.Getenv("NOTIFY_SOCKET") != ""
+
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 63)
+	if err == nil && watchdogForUs() {
+		info.systemdWatchdog = time.Duration(usec) * time.Microsecond
+	}
+
+	info.systemdListenFDs = systemdListenFDCount()
+}
+
+// Returns the number of sockets passed to this process by systemd socket
+// activation. Sockets passed to the parent of a child started by daemon.Fork
+// are passed on to the child, so LISTEN_PID may also be the parent's PID.
+func systemdListenFDCount() int {
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	pid := os.Getenv("LISTEN_PID")
+	if pid == strconv.Itoa(os.Getpid()) || (daemon.IsForkedChild() && pid == strconv.Itoa(os.Getppid())) {
+		return n
+	}
+
+	return 0
+}
+
+// WATCHDOG_PID, if set, must match the PID of this process.
+func watchdogForUs() bool {
+	pid := os.Getenv("WATCHDOG_PID")
+	return pid == "" || pid == strconv.Itoa(os.Getpid())
+}
+
+// Returns listeners for the sockets passed by systemd socket activation.
+func (info *Info) systemdListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+info.systemdListenFDs; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket passed by systemd: %v", err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	// Don't pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return listeners, nil
+}
+
+// Opens and locks each file in pidFileNames. If any file cannot be opened, any
+// files already opened are closed.
 func (info *Info) openPIDFile() error {
-	f, err := pidfile.Open(info.pidFileName)
-	info.pidFile = f
-	return err
+	for _, name := range info.pidFileNames {
+		f, err := pidfile.Open(name)
+		if err != nil {
+			info.closePIDFile()
+			return err
+		}
+
+		info.pidFiles = append(info.pidFiles, f)
+	}
+
+	return nil
+}
+
+// Opens and locks the PID file at path in the parent, for
+// Config.PIDFileParent.
+func openParentPIDFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockPIDFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock PID file %q: %v", path, err)
+	}
+
+	return f, nil
+}
+
+// In a child started by daemon.Fork with Config.PIDFileParent set, returns the
+// PID file opened and locked by the parent, after checking that it is still
+// locked and writing the PID of this process to it. Returns nil otherwise.
+// This happens before the child reports to the parent that it has started, so
+// the parent does not exit until the child holds the lock.
+func inheritedPIDFile() (*os.File, error) {
+	fdStr := os.Getenv(pidFileFDEnv)
+	if fdStr == "" {
+		return nil, nil
+	}
+
+	os.Unsetenv(pidFileFDEnv)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", pidFileFDEnv, fdStr)
+	}
+
+	f := inheritFD(fd)
+	err = lockPIDFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock PID file: %v", err)
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot write PID file: %v", err)
+	}
+
+	return f, nil
 }
 
 func (info *Info) closePIDFile() {
-	if info.pidFile != nil {
-		info.pidFile.Close()
+	for _, f := range info.pidFiles {
+		if f != nil {
+			f.Close()
+		}
 	}
+
+	info.pidFiles = nil
 }
 
 func (h *ihandler) DropPrivileges() error {
@@ -105,6 +795,39 @@ func (h *ihandler) DropPrivileges() error {
 		return nil
 	}
 
+	// Read credentials while we still can.
+	h.info.loadCredentials()
+
+	// Likewise files requested with PreDropOpen or in PreChrootFiles.
+	for _, path := range h.info.Config.PreChrootFiles {
+		err := h.preDrop.request(path, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := h.preDrop.open()
+	if err != nil {
+		return err
+	}
+
+	// Retrieve sockets from launchd while we still can.
+	if h.info.Config.LaunchdSocket != "" {
+		listeners, err := launchd.ActivateSocket(h.info.Config.LaunchdSocket)
+		if err != nil {
+			return err
+		}
+		h.listeners = append(h.listeners, listeners...)
+	}
+
+	if h.info.systemdListenFDs > 0 {
+		listeners, err := h.info.systemdListeners()
+		if err != nil {
+			return err
+		}
+		h.listeners = append(h.listeners, listeners...)
+	}
+
 	// Extras
 	if !h.info.NoBanSuid {
 		// Try and bansuid, but don't process errors. It may not be supported on
@@ -115,12 +838,9 @@ func (h *ihandler) DropPrivileges() error {
 	}
 
 	// Various fixups
-	if h.info.Config.UID != "" && h.info.Config.GID == "" {
-		gid, err := passwd.GetGIDForUID(h.info.Config.UID)
-		if err != nil {
-			return err
-		}
-		h.info.Config.GID = strconv.FormatInt(int64(gid), 10)
+	uid, gid, err := h.info.serviceIDs()
+	if err != nil {
+		return err
 	}
 
 	if h.info.DefaultChroot == "" {
@@ -132,47 +852,294 @@ func (h *ihandler) DropPrivileges() error {
 		chrootPath = h.info.DefaultChroot
 	}
 
-	uid := -1
-	gid := -1
-	if h.info.Config.UID != "" {
-		var err error
-		uid, err = passwd.ParseUID(h.info.Config.UID)
-		if err != nil {
-			return err
+	if uid > 0 {
+		if chrootPath != "/" {
+			err := h.info.startChrootTeardown(chrootPath)
+			if err != nil {
+				return fmt.Errorf("Failed to start chroot teardown helper: %v", err)
+			}
 		}
 
-		gid, err = passwd.ParseGID(h.info.Config.GID)
-		if err != nil {
-			return err
+		if h.info.ChrootSetup != nil && chrootPath != "/" {
+			err := h.info.ChrootSetup(chrootPath)
+			if err != nil {
+				return fmt.Errorf("Failed to set up chroot: %v", err)
+			}
 		}
-	}
 
-	if (uid <= 0) != (gid <= 0) {
-		return fmt.Errorf("Either both or neither of the UID and GID must be positive")
-	}
+		if chrootPath != "/" && !h.info.Config.SkipChrootSafetyCheck {
+			err := daemon.CheckChrootSafety(chrootPath, uid)
+			if err != nil {
+				if h.info.Config.Chroot != "" {
+					return fmt.Errorf("Unsafe chroot: %v", err)
+				}
+
+				// Chrooting into DefaultChroot is best-effort.
+				chrootPath = "/"
+			}
+		}
+
+		// Bind mounts are only created once the chroot is known to be safe, as
+		// they are made as root.
+		if chrootPath != "/" {
+			err := h.info.bindMounts(chrootPath)
+			if err != nil {
+				return fmt.Errorf("Failed to set up chroot: %v", err)
+			}
+		}
 
-	if uid > 0 {
 		chrootErr, err := daemon.DropPrivileges(uid, gid, chrootPath)
 		if err != nil {
 			return fmt.Errorf("Failed to drop privileges: %v", err)
 		}
+		h.changedIDs = true
 		if chrootErr != nil && h.info.Config.Chroot != "" && h.info.Config.Chroot != "/" {
 			return fmt.Errorf("Failed to chroot: %v", chrootErr)
 		}
+		if chrootErr == nil {
+			h.info.chrootPath = chrootPath
+		}
 	} else if h.info.Config.Chroot != "" && h.info.Config.Chroot != "/" {
 		return fmt.Errorf("Must use privilege dropping to use chroot; set -uid")
 	}
 
 	// If we still have any caps (maybe because we didn't setuid), try and drop them.
-	err := caps.Drop()
+	err = caps.Drop()
 	if err != nil {
 		return fmt.Errorf("cannot drop caps: %v", err)
 	}
 
+	err = h.unveil()
+	if err != nil {
+		return err
+	}
+
 	if !h.info.AllowRoot && daemon.IsRoot() {
 		return fmt.Errorf("Daemon must not run as root or with capabilities; run as non-root user or use -uid")
 	}
 
+	h.preDrop.setDropped()
 	h.dropped = true
 	return nil
 }
+
+// Returns the UID and GID to drop privileges to, or -1 for each if privileges
+// are not to be dropped. If Config.UID is set but Config.GID is not, the GID
+// is looked up and Config.GID is set to it.
+func (info *Info) serviceIDs() (uid, gid int, err error) {
+	if info.Config.UID != "" && info.Config.GID == "" {
+		gid, err := passwd.GetGIDForUID(info.Config.UID)
+		if err != nil {
+			return -1, -1, err
+		}
+		info.Config.GID = strconv.FormatInt(int64(gid), 10)
+	}
+
+	uid, gid = -1, -1
+	if info.Config.UID != "" {
+		uid, err = passwd.ParseUID(info.Config.UID)
+		if err != nil {
+			return -1, -1, err
+		}
+
+		gid, err = passwd.ParseGID(info.Config.GID)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+
+	if (uid <= 0) != (gid <= 0) {
+		return -1, -1, fmt.Errorf("Either both or neither of the UID and GID must be positive")
+	}
+
+	return uid, gid, nil
+}
+
+// Checks that the GID for the given UID can be determined, for Config.Validate.
+func checkUserGroup(uid string) error {
+	_, err := passwd.GetGIDForUID(uid)
+	if err != nil {
+		return fmt.Errorf("cannot determine GID for UID %q, set GID explicitly: %v", uid, err)
+	}
+
+	return nil
+}
+
+func isWritable(path string) bool {
+	return syscall.Access(path, 2 /* W_OK */) == nil
+}
+
+// Creates the directories configured for the service, such as
+// Config.RuntimeDir, owned by the user the service runs as. Directories
+// provided by systemd are used instead where available.
+func (info *Info) createServiceDirs() error {
+	dirs := []struct {
+		path    string
+		envName string
+		base    string
+		mode    os.FileMode
+		result  *string
+	}{
+		{info.Config.RuntimeDir, "RUNTIME_DIRECTORY", "/var/run", 0755, &info.runtimeDir},
+		{info.Config.StateDir, "STATE_DIRECTORY", "/var/lib", 0700, &info.stateDir},
+		{info.Config.CacheDir, "CACHE_DIRECTORY", "/var/cache", 0700, &info.cacheDir},
+		{info.Config.LogDir, "LOGS_DIRECTORY", "/var/log", 0700, &info.logDir},
+	}
+
+	for _, d := range dirs {
+		if d.path == "" {
+			continue
+		}
+
+		uid, gid, err := info.serviceIDs()
+		if err != nil {
+			return err
+		}
+
+		*d.result, err = createServiceDir(d.path, d.envName, d.base, d.mode, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the directory given by the environment variable envName if set, as
+// systemd sets when it has created the directory itself. Otherwise, creates the
+// directory path, which is relative to base if not absolute, with the given
+// mode, and chowns it to uid and gid if uid is positive.
+//
+// The mode and owner of an existing directory are only changed if it is
+// directly under base, so that pointing a service at an existing directory
+// such as /etc does not change its permissions.
+func createServiceDir(path, envName, base string, mode os.FileMode, uid, gid int) (string, error) {
+	// systemd separates multiple directories with colons.
+	if dir := os.Getenv(envName); dir != "" {
+		return strings.SplitN(dir, ":", 2)[0], nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+
+	fi, err := os.Lstat(path)
+	switch {
+	case err == nil:
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%q exists and is not a directory", path)
+		}
+
+		if filepath.Dir(path) != base {
+			return path, nil
+		}
+
+	case os.IsNotExist(err):
+		err = os.MkdirAll(path, mode)
+		if err != nil {
+			return "", err
+		}
+
+	default:
+		return "", err
+	}
+
+	// The mode passed to MkdirAll is subject to the umask.
+	err = os.Chmod(path, mode)
+	if err != nil {
+		return "", err
+	}
+
+	if uid > 0 {
+		err = os.Chown(path, uid, gid)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return path, nil
+}
+
+func (h *ihandler) unveil() error {
+	if len(h.info.Config.UnveilPaths) == 0 {
+		return nil
+	}
+
+	for _, p := range h.info.Config.UnveilPaths {
+		err := unveil.Unveil(p.Path, p.Permissions)
+		if err != nil {
+			return fmt.Errorf("cannot unveil %q: %v", p.Path, err)
+		}
+	}
+
+	err := unveil.UnveilBlock()
+	if err != nil {
+		return fmt.Errorf("cannot block further unveiling: %v", err)
+	}
+
+	return nil
+}
+
+// Listens on the management socket at path. The socket is created in a new
+// private directory beside path, restricted to its owner and only then moved
+// into place, so that it is never reachable with looser permissions. The
+// socket is removed when the listener is closed.
+func listenManagementSocket(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".management")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "socket")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &managementListener{l, path}, nil
+}
+
+// A listener which removes the socket at path when closed.
+type managementListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *managementListener) Close() error {
+	os.Remove(l.path)
+	return l.UnixListener.Close()
+}
+
+// Checks that the client connected to the management socket runs as root or
+// as the same user as the service. Where the platform cannot report this, the
+// permissions of the socket are relied upon.
+func checkManagementPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+
+	uid, _, err := peercred.Get(uc)
+	if err == peercred.ErrNotSupported {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot get credentials of client: %v", err)
+	}
+
+	if uid != 0 && uid != os.Geteuid() {
+		return fmt.Errorf("client runs as another user (UID %d)", uid)
+	}
+
+	return nil
+}
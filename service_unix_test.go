@@ -0,0 +1,149 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Set in the environment of the subprocess which runs the body of
+// TestDropPrivilegesTwice.
+const dropPrivilegesTestEnv = "_SERVICE_TEST_DROP_PRIVILEGES"
+
+// Set in the environment of the subprocesses which act as the parent and child
+// in TestPIDFileParentLock, to "parent:<path>" or "child".
+const pidFileLockTestEnv = "_SERVICE_TEST_PID_FILE_LOCK"
+
+// DropPrivileges really drops privileges; no UID is set, so this only drops
+// capabilities, but that must not affect the rest of the tests, so the test is
+// run in a subprocess.
+func TestDropPrivilegesTwice(t *testing.T) {
+	if os.Getenv(dropPrivilegesTestEnv) == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivilegesTwice$")
+		cmd.Env = append(os.Environ(), dropPrivilegesTestEnv+"=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("subprocess failed: %v\n%s", err, out)
+		}
+		return
+	}
+
+	h := &ihandler{
+		info: &Info{Name: "test", AllowRoot: true, NoBanSuid: true},
+	}
+
+	if h.HasDropped() {
+		t.Fatalf("HasDropped returned true before DropPrivileges")
+	}
+
+	err := h.DropPrivileges()
+	if err != nil {
+		t.Fatalf("DropPrivileges: %v", err)
+	}
+	if !h.HasDropped() {
+		t.Fatalf("HasDropped returned false after DropPrivileges")
+	}
+
+	// The second call must not repeat any of the work, which would now fail.
+	h.info.Config.Chroot = "/nonexistent"
+	err = h.DropPrivileges()
+	if err != nil {
+		t.Fatalf("second DropPrivileges: %v", err)
+	}
+}
+
+// The parent opens and locks the PID file and starts the child, which inherits
+// it and reports its PID once it has checked the lock; the parent then exits.
+// The lock must still be held afterwards, until the child exits.
+func TestPIDFileParentLock(t *testing.T) {
+	switch role := os.Getenv(pidFileLockTestEnv); {
+	case strings.HasPrefix(role, "parent:"):
+		f, err := openParentPIDFile(strings.TrimPrefix(role, "parent:"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestPIDFileParentLock$")
+		cmd.Env = append(os.Environ(), pidFileLockTestEnv+"=child", pidFileFDEnv+"=3")
+		cmd.ExtraFiles = []*os.File{f}
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		err = cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Exit(0)
+
+	case role == "child":
+		f, err := inheritedPIDFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		os.Stdout.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+
+		// Hold the file until the test closes stdin.
+		io.Copy(io.Discard, os.Stdin)
+		os.Exit(0)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pid")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPIDFileParentLock$")
+	cmd.Env = append(os.Environ(), pidFileLockTestEnv+"=parent:"+path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("child did not start: %v", err)
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatalf("parent failed: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != line {
+		t.Fatalf("PID file contains %q, expected child PID %q", b, line)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Take the record lock which pidfile.Open would take.
+	err = syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_WRLCK})
+	if err == nil {
+		t.Fatalf("PID file was not locked after the parent exited")
+	}
+}
@@ -1,11 +1,19 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unsafe"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
 	"gopkg.in/hlandau/svcutils.v1/exepath"
 )
@@ -14,12 +22,59 @@ import (
 // It is present to allow code relying upon it to compile upon all platforms.
 var EmptyChrootPath = ""
 
-var errNotSupported = fmt.Errorf("not supported")
+// Environment variables kept when Config.ClearEnv is set. Variables relating
+// to the user profile, such as USERPROFILE and APPDATA, are not kept.
+var keptEnvVars = []string{
+	"PATH", "PATHEXT", "SystemRoot", "SystemDrive", "windir", "ComSpec",
+	"TEMP", "TMP",
+}
+
+// Windows has no reload signal, so plugins are never loaded from
+// Info.PluginDir.
+var reloadSignals []os.Signal
+
+var quitSignals []os.Signal
 
 func systemdUpdateStatus(status string) error {
 	return errNotSupported
 }
 
+// Forking is not supported on Windows, so there is never a parent to report to.
+func reportForkResult(err error) {
+}
+
+// Manager.Restart is not supported, as a process cannot replace itself.
+const canRestart = false
+
+func (info *Info) execRestart() error {
+	return errNotSupported
+}
+
+func inheritFD(fd int) *os.File {
+	return os.NewFile(uintptr(fd), "inherited")
+}
+
+func (info *Info) notifyStarted() {
+}
+
+// The platform on which the service is running: "unix" or "windows".
+const CurrentPlatform = "windows"
+
+func platformFeatures() []string {
+	return []string{"windows-service", "eventlog"}
+}
+
+// UIDs are not used on Windows.
+func checkUserGroup(uid string) error {
+	return nil
+}
+
+// Access checks are not performed on Windows; the service is assumed to be
+// able to write wherever it is configured to.
+func isWritable(path string) bool {
+	return true
+}
+
 func usingPlatform(platformName string) bool {
 	return platformName == "windows"
 }
@@ -30,51 +85,225 @@ type handler struct {
 	info        *Info
 	startedChan chan struct{}
 	stopChan    chan struct{}
+	statusMutex sync.Mutex
 	status      string
+	components  componentStatuses
+	history     statusHistory
 	dropped     bool
+	elog        *eventlog.Log
+	goroutines  goroutineGroup
+	ready       readiness
+	draining    atomic.Bool
+	preDrop     preDropFiles
+}
+
+// The event ID used for all events written to the event log.
+const eventID = 1
+
+func (h *handler) logInfo(msg string) {
+	h.info.logger().Info(msg, "service", h.info.Name)
+	if h.elog != nil {
+		h.elog.Info(eventID, msg)
+	}
+}
+
+func (h *handler) logError(msg string) {
+	h.info.logError(msg, nil)
+	if h.elog != nil {
+		h.elog.Error(eventID, msg)
+	}
 }
 
 func (h *handler) DropPrivileges() error {
+	if h.dropped {
+		return nil
+	}
+
+	h.info.loadCredentials()
+
+	err := h.preDrop.open()
+	if err != nil {
+		return err
+	}
+
+	h.preDrop.setDropped()
 	h.dropped = true
 	return nil
 }
 
 func (h *ihandler) DropPrivileges() error {
+	if h.dropped {
+		return nil
+	}
+
+	h.info.loadCredentials()
+
+	err := h.preDrop.open()
+	if err != nil {
+		return err
+	}
+
+	h.preDrop.setDropped()
 	h.dropped = true
 	return nil
 }
 
+func (h *handler) PreDropOpen(path string, flags int) error {
+	return h.preDrop.request(path, flags)
+}
+
+func (h *handler) PreDropFiles() map[string]*os.File {
+	return h.preDrop.get()
+}
+
+// Returns true if DropPrivileges has succeeded.
+func (h *handler) HasDropped() bool {
+	return h.dropped
+}
+
 func (h *handler) SetStarted() {
 	if !h.dropped {
 		panic("service must call DropPrivileges before calling SetStarted")
 	}
 
+	if !h.ready.setStarted() {
+		return
+	}
+
 	select {
 	case h.startedChan <- struct{}{}:
 	default:
 	}
 }
 
+// The service control manager has no notion of readiness, so changes are only
+// logged.
+func (h *handler) SetReady(ready bool) {
+	if !h.ready.set(ready) {
+		return
+	}
+
+	if ready {
+		h.logInfo(fmt.Sprintf("%s ready", h.info.Name))
+	} else {
+		h.logInfo(fmt.Sprintf("%s not ready", h.info.Name))
+	}
+}
+
+func (h *handler) IsReady() bool {
+	return h.ready.isReady()
+}
+
+func (h *handler) GetCredential(name string) ([]byte, error) {
+	return h.info.getCredential(name)
+}
+
+func (h *handler) SocketListeners() []net.Listener {
+	return nil
+}
+
+func (h *handler) Restart() error {
+	return errNotSupported
+}
+
+func (h *handler) InheritedFiles() []*os.File {
+	return nil
+}
+
+func (h *handler) RuntimeDir() string {
+	return ""
+}
+
+func (h *handler) StateDir() string {
+	return ""
+}
+
+func (h *handler) CacheDir() string {
+	return ""
+}
+
+func (h *handler) LogDir() string {
+	return ""
+}
+
+func (h *handler) Go(f func(ctx context.Context)) error {
+	return h.goroutines.Go(h.stopChan, f)
+}
+
+func (h *handler) Wait() error {
+	return h.goroutines.Wait()
+}
+
 func (h *handler) StopChan() <-chan struct{} {
 	return h.stopChan
 }
 
+func (h *handler) IsDraining() bool {
+	return h.draining.Load()
+}
+
 func (h *handler) SetStatus(status string) {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
 	h.status = status
+	h.history.add(h.info.Config.StatusHistorySize, StatusEntry{Time: time.Now(), Status: status})
+	publishStatus(h.info.Name, h.components.join(h.status))
+}
+
+func (h *handler) SetComponentStatus(component, status string) {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+	h.components.set(component, status)
+	h.history.add(h.info.Config.StatusHistorySize, StatusEntry{Time: time.Now(), Status: status, Component: component})
+	publishStatus(h.info.Name, h.components.join(h.status))
+}
+
+func (h *handler) StatusHistory(n int) []StatusEntry {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+	return h.history.last(n)
+}
+
+func (h *handler) ClearComponentStatus(component string) {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+	h.components.clear(component)
+	publishStatus(h.info.Name, h.components.join(h.status))
 }
 
 func (h *handler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	cmdsAccepted := svc.AcceptStop | svc.AcceptShutdown
+	preShutdownTimeout := h.info.Config.PreShutdownTimeout
+	if preShutdownTimeout > 0 {
+		cmdsAccepted |= svc.AcceptPreShutdown
+	}
+
 	changes <- svc.Status{State: svc.StartPending}
 
 	h.startedChan = make(chan struct{}, 1)
 	h.stopChan = make(chan struct{})
 	doneChan := make(chan error)
-	started := false
 	stopping := false
 
+	// Begins stopping the service, after Config.DrainTimeout if set.
+	drainTimeout := h.info.Config.DrainTimeout
+	var drainTimerChan <-chan time.Time
+	stop := func() {
+		if stopping {
+			return
+		}
+
+		stopping = true
+		h.draining.Store(true)
+		if drainTimeout > 0 {
+			drainTimerChan = time.After(drainTimeout)
+		} else {
+			close(h.stopChan)
+		}
+	}
+
 	go func() {
-		err := h.info.RunFunc(h)
+		err := h.info.callRunFunc(h.info.RunFunc, h)
 		doneChan <- err
 	}()
 
@@ -90,22 +319,25 @@ loop:
 
 			case svc.Stop, svc.Shutdown:
 				// Service stop is pending. Don't accept any more commands while pending.
-				changes <- svc.Status{State: svc.StopPending}
-				if !stopping {
-					stopping = true
-					close(h.stopChan)
-				}
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(drainTimeout / time.Millisecond)}
+				stop()
+
+			case svc.PreShutdown:
+				// As above, but advertise how long we may take to stop.
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(preShutdownTimeout / time.Millisecond)}
+				stop()
 
 			default:
 				// Unexpected control request
 			}
 
+		case <-drainTimerChan:
+			drainTimerChan = nil
+			close(h.stopChan)
+
 		case <-h.startedChan:
-			if started {
-				panic("must not call SetStarted() more than once")
-			}
-			started = true
 			changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+			h.logInfo(fmt.Sprintf("%s started", h.info.Name))
 
 		case err = <-doneChan:
 			break loop
@@ -113,13 +345,63 @@ loop:
 	}
 
 	if err == nil {
+		h.logInfo(fmt.Sprintf("%s stopped", h.info.Name))
 		changes <- svc.Status{State: svc.Stopped}
 		return false, 0
 	} else {
+		h.logError(fmt.Sprintf("%s failed: %v", h.info.Name, err))
 		return false, 1
 	}
 }
 
+// Returns the description of the service as shown by the service control
+// manager, which includes the version, if any.
+func (info *Info) serviceDescription() string {
+	if info.Version == "" {
+		return info.Description
+	}
+
+	return fmt.Sprintf("%s (version %s)", info.Description, info.Version)
+}
+
+// Returns the backend used by Config.AutoInstall and whether the service is
+// already installed. Returns ok=false if the service is running under the
+// service control manager.
+func (info *Info) autoInstallBackend() (b ServiceBackend, installed, ok bool) {
+	if !isInteractive() {
+		return nil, false, false
+	}
+
+	b = info.Backend
+	if b == nil {
+		b = &WindowsBackend{}
+	}
+
+	serviceManager, err := mgr.Connect()
+	if err != nil {
+		return b, false, true
+	}
+	defer serviceManager.Disconnect()
+
+	service, err := serviceManager.OpenService(info.Name)
+	if err != nil {
+		return b, false, true
+	}
+	service.Close()
+
+	return b, true, true
+}
+
+// Returns the command line registered with the service control manager for
+// the given executable and arguments, quoted as CreateService does.
+func serviceCommandLine(exe string, args []string) string {
+	s := syscall.EscapeArg(exe)
+	for _, arg := range args {
+		s += " " + syscall.EscapeArg(arg)
+	}
+	return s
+}
+
 func isInteractive() bool {
 	interactive, err := svc.IsAnInteractiveSession()
 	if err != nil {
@@ -146,19 +428,169 @@ func (info *Info) installService() error {
 		return fmt.Errorf("service %s already exists", svcName)
 	}
 
-	// Install the service.
+	recoveryActions, err := info.recoveryActions()
+	if err != nil {
+		return err
+	}
+
+	// Install the service. The description is set separately once the service
+	// exists, as passing it to CreateService is not reliable on older versions
+	// of Windows.
 	service, err = serviceManager.CreateService(svcName, exepath.Abs, mgr.Config{
-		DisplayName:  info.Title,
-		Description:  info.Description,
-		StartType:    mgr.StartAutomatic,
-		ErrorControl: mgr.ErrorNormal,
-	})
+		DisplayName:      info.Title,
+		StartType:        mgr.StartAutomatic,
+		ErrorControl:     mgr.ErrorNormal,
+		Dependencies:     info.Config.WindowsDependencies,
+		ServiceStartName: info.Config.WindowsServiceAccount,
+		Password:         info.Config.WindowsPassword,
+	}, info.Config.ServiceArgs...)
 	if err != nil {
 		return err
 	}
 	defer service.Close()
 
-	// TODO: event log
+	// If the service cannot be configured as requested, remove it again rather
+	// than leaving it installed without, e.g., its recovery actions.
+	err = info.configureNewService(service, recoveryActions)
+	if err != nil {
+		if derr := service.Delete(); derr != nil {
+			return fmt.Errorf("%v (the service could not be removed again: %v)", err, derr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Sets the configuration of a newly created service which cannot be passed to
+// CreateService, and registers its event log source.
+func (info *Info) configureNewService(service *mgr.Service, recoveryActions []mgr.RecoveryAction) error {
+	err := setServiceDescription(service, info.serviceDescription())
+	if err != nil {
+		return err
+	}
+
+	err = info.setRecovery(service, recoveryActions)
+	if err != nil {
+		return err
+	}
+
+	err = info.setPreShutdownTimeout(service)
+	if err != nil {
+		return err
+	}
+
+	if info.Config.WindowsEventLog {
+		err = eventlog.InstallAsEventCreate(info.Name, eventlog.Error|eventlog.Warning|eventlog.Info)
+		if err != nil {
+			return fmt.Errorf("could not register event log source: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SERVICE_PRESHUTDOWN_INFO
+type servicePreShutdownInfo struct {
+	PreShutdownTimeout uint32 // In milliseconds.
+}
+
+// Sets the description of an installed service.
+func setServiceDescription(service *mgr.Service, desc string) error {
+	p, err := windows.UTF16PtrFromString(desc)
+	if err != nil {
+		return err
+	}
+
+	sd := windows.SERVICE_DESCRIPTION{Description: p}
+	err = windows.ChangeServiceConfig2(service.Handle,
+		windows.SERVICE_CONFIG_DESCRIPTION, (*byte)(unsafe.Pointer(&sd)))
+	if err != nil {
+		return fmt.Errorf("could not set service description: %v", err)
+	}
+
+	return nil
+}
+
+// Registers Config.PreShutdownTimeout with the service control manager, if it
+// is set.
+func (info *Info) setPreShutdownTimeout(service *mgr.Service) error {
+	if info.Config.PreShutdownTimeout <= 0 {
+		return nil
+	}
+
+	psi := servicePreShutdownInfo{
+		PreShutdownTimeout: uint32(info.Config.PreShutdownTimeout / time.Millisecond),
+	}
+	err := windows.ChangeServiceConfig2(service.Handle,
+		windows.SERVICE_CONFIG_PRESHUTDOWN_INFO, (*byte)(unsafe.Pointer(&psi)))
+	if err != nil {
+		return fmt.Errorf("could not set pre-shutdown timeout: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the recovery actions specified by Config.WindowsRecovery, or nil if
+// it is not set.
+func (info *Info) recoveryActions() ([]mgr.RecoveryAction, error) {
+	rc := info.Config.WindowsRecovery
+	if rc == nil {
+		return nil, nil
+	}
+
+	var actions []mgr.RecoveryAction
+	for _, name := range []string{rc.FirstFailureAction, rc.SecondFailureAction, rc.SubsequentFailureAction} {
+		var t int
+		switch name {
+		case "", "none":
+			t = mgr.NoAction
+		case "restart":
+			t = mgr.ServiceRestart
+		case "run":
+			t = mgr.RunCommand
+		case "reboot":
+			t = mgr.ComputerReboot
+		default:
+			return nil, fmt.Errorf("unknown recovery action: %q", name)
+		}
+
+		actions = append(actions, mgr.RecoveryAction{Type: t, Delay: rc.Delay})
+	}
+
+	return actions, nil
+}
+
+// Applies Config.WindowsRecovery to the service, if it is set.
+func (info *Info) setRecovery(service *mgr.Service, actions []mgr.RecoveryAction) error {
+	rc := info.Config.WindowsRecovery
+	if rc == nil {
+		return nil
+	}
+
+	resetPeriod := rc.ResetPeriodSecs
+	if resetPeriod == 0 {
+		resetPeriod = windows.INFINITE
+	}
+
+	err := service.SetRecoveryActions(actions, resetPeriod)
+	if err != nil {
+		return fmt.Errorf("could not set recovery actions: %v", err)
+	}
+
+	if rc.RebootMsg != "" {
+		err = service.SetRebootMessage(rc.RebootMsg)
+		if err != nil {
+			return fmt.Errorf("could not set reboot message: %v", err)
+		}
+	}
+
+	if rc.CommandLine != "" {
+		err = service.SetRecoveryCommand(rc.CommandLine)
+		if err != nil {
+			return fmt.Errorf("could not set recovery command: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -186,9 +618,66 @@ func (info *Info) removeService() error {
 		return err
 	}
 
+	// Remove the event log source, if one was registered.
+	eventlog.Remove(svcName)
+
 	return nil
 }
 
+func (info *Info) updateService() error {
+	svcName := info.Name
+
+	// Connect to the Windows service manager.
+	serviceManager, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer serviceManager.Disconnect()
+
+	// Ensure the service exists.
+	service, err := serviceManager.OpenService(svcName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", svcName)
+	}
+	defer service.Close()
+
+	recoveryActions, err := info.recoveryActions()
+	if err != nil {
+		return err
+	}
+
+	// Update the service metadata, leaving everything else unchanged. In
+	// particular the start type is kept, so that a service which has been
+	// disabled or set to start manually stays that way.
+	config, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("could not retrieve service configuration: %v", err)
+	}
+
+	config.BinaryPathName = serviceCommandLine(exepath.Abs, info.Config.ServiceArgs)
+	config.DisplayName = info.Title
+	config.Description = info.serviceDescription()
+	if info.Config.WindowsDependencies != nil {
+		config.Dependencies = info.Config.WindowsDependencies
+	}
+	if info.Config.WindowsServiceAccount != "" {
+		config.ServiceStartName = info.Config.WindowsServiceAccount
+		config.Password = info.Config.WindowsPassword
+	}
+
+	err = service.UpdateConfig(config)
+	if err != nil {
+		return fmt.Errorf("could not update service configuration: %v", err)
+	}
+
+	err = info.setRecovery(service, recoveryActions)
+	if err != nil {
+		return err
+	}
+
+	return info.setPreShutdownTimeout(service)
+}
+
 func (info *Info) startService() error {
 	svcName := info.Name
 
@@ -252,30 +741,125 @@ func (info *Info) stopService() error {
 }
 
 func (info *Info) runAsService() error {
-	// TODO: event log
+	h := &handler{info: info}
+
+	if info.Config.WindowsEventLog {
+		elog, err := eventlog.Open(info.Name)
+		if err != nil {
+			return fmt.Errorf("could not open event log: %v", err)
+		}
+		defer elog.Close()
+
+		h.elog = elog
+		h.logInfo(fmt.Sprintf("%s starting", info.Name))
+	}
 
-	err := svc.Run(info.Name, &handler{info: info})
+	err := svc.Run(info.Name, h)
 	if err != nil {
+		h.logError(fmt.Sprintf("%s could not run as service: %v", info.Name, err))
 		return err
 	}
 
 	return nil
 }
 
-func (info *Info) serviceMain() error {
-	switch info.Config.Command {
-	case "install":
-		return info.installService()
-	case "remove":
-		return info.removeService()
-	case "start":
-		return info.startService()
-	case "stop":
-		return info.stopService()
+func (info *Info) queryService() (ServiceState, error) {
+	svcName := info.Name
+
+	// Connect to the Windows service manager.
+	serviceManager, err := mgr.Connect()
+	if err != nil {
+		return StateUnknown, err
+	}
+	defer serviceManager.Disconnect()
+
+	service, err := serviceManager.OpenService(svcName)
+	if err != nil {
+		return StateUnknown, fmt.Errorf("could not access service: %v", err)
+	}
+	defer service.Close()
+
+	status, err := service.Query()
+	if err != nil {
+		return StateUnknown, fmt.Errorf("could not retrieve service status: %v", err)
+	}
+
+	switch status.State {
+	case svc.Stopped:
+		return StateStopped, nil
+	case svc.StartPending:
+		return StateStartPending, nil
+	case svc.StopPending:
+		return StateStopPending, nil
+	case svc.Running:
+		return StateRunning, nil
 	default:
-		// ...
+		return StateUnknown, nil
+	}
+}
+
+func (b *WindowsBackend) Install(info *Info) error {
+	return info.installService()
+}
+
+func (b *WindowsBackend) Remove(info *Info) error {
+	return info.removeService()
+}
+
+func (b *WindowsBackend) Update(info *Info) error {
+	return info.updateService()
+}
+
+func (b *WindowsBackend) Start(info *Info) error {
+	return info.startService()
+}
+
+func (b *WindowsBackend) Stop(info *Info) error {
+	return info.stopService()
+}
+
+func (b *WindowsBackend) Status(info *Info) (ServiceState, error) {
+	return info.queryService()
+}
+
+func defaultBackend() (ServiceBackend, error) {
+	return &WindowsBackend{}, nil
+}
+
+// USERNAME is not reliable for this, as under LocalSystem it is usually the
+// computer account rather than SYSTEM; an elevated token is checked instead.
+func isRoot() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// Windows has no chroot, so ChrootTeardown is never called.
+func (info *Info) runChrootTeardown() (bool, error) {
+	return false, nil
+}
+
+func willDropPrivileges(cfg *Config) bool {
+	return false
+}
+
+func isContainer() bool {
+	return false
+}
+
+func detectInitSystem() string {
+	return "windows"
+}
+
+func (info *Info) serviceMain() error {
+	if ran, err := info.runCommand(); ran {
+		return err
 	}
 
+	if installed, err := info.autoInstall(); installed {
+		return err
+	}
+
+	info.prepareEnv()
+
 	interactive := isInteractive()
 	if !interactive {
 		return info.runAsService()
@@ -284,6 +868,15 @@ func (info *Info) serviceMain() error {
 	return info.runInteractively()
 }
 
+// Config.ManagementSocket is rejected by Config.Validate on Windows.
+func listenManagementSocket(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("management sockets are not supported on Windows")
+}
+
+func checkManagementPeer(conn net.Conn) error {
+	return fmt.Errorf("management sockets are not supported on Windows")
+}
+
 // Copyright © 2013-2014 Conformal Systems LLC.
 //
 // Permission to use, copy, modify, and distribute this software for any
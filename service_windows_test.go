@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/windows/svc/mgr"
+	"gopkg.in/hlandau/svcutils.v1/exepath"
+)
+
+// Installs a service and checks that its description is registered. This
+// requires administrative privileges, and is skipped without them.
+func TestInstallServiceDescription(t *testing.T) {
+	m, err := mgr.Connect()
+	if err != nil {
+		t.Skipf("cannot connect to service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	if exepath.Abs == "" {
+		exepath.Abs, _ = os.Executable()
+	}
+
+	info := &Info{
+		Name:        fmt.Sprintf("servicetest%d", os.Getpid()),
+		Title:       "Service Test",
+		Description: "Test service installed by TestInstallServiceDescription.",
+		Version:     "1.2.3",
+	}
+
+	err = info.installService()
+	if err != nil {
+		t.Fatalf("installService: %v", err)
+	}
+	defer info.removeService()
+
+	s, err := m.OpenService(info.Name)
+	if err != nil {
+		t.Fatalf("OpenService: %v", err)
+	}
+	defer s.Close()
+
+	config, err := s.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+
+	expected := "Test service installed by TestInstallServiceDescription. (version 1.2.3)"
+	if config.Description != expected {
+		t.Fatalf("description is %q, expected %q", config.Description, expected)
+	}
+}
+
+// Management sockets cannot be restricted to the service user on Windows.
+func TestValidateManagementSocket(t *testing.T) {
+	cfg := Config{ManagementSocket: `C:\service.sock`}
+	if len(cfg.Validate()) == 0 {
+		t.Fatalf("ManagementSocket was accepted")
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Runs several independent services within a single process, for example an
+// HTTP server and a background worker.
+//
+// Each service added to the group is run concurrently with its own Manager.
+// Privileges are dropped once for the whole group, after every service has
+// called DropPrivileges, and the group is reported as started once every
+// service has called SetStarted. All services share a stop channel, which is
+// closed when the process is asked to stop or when any service returns an
+// error.
+//
+// The first Info added is the primary service. Its fields other than RunFunc
+// and NewFunc, including Name, Config and RecoverPanic, control how the
+// process is run and apply to all services. Of the other Infos, only Name,
+// RunFunc and NewFunc are used.
+//
+// The zero value is an empty group.
+type ServiceGroup struct {
+	infos []*Info
+}
+
+// Adds a service to the group. Returns the group so that calls can be chained.
+func (g *ServiceGroup) Add(info *Info) *ServiceGroup {
+	g.infos = append(g.infos, info)
+	return g
+}
+
+// Runs the group, as for Main.
+func (g *ServiceGroup) Main() {
+	err := g.Run()
+	if err != nil {
+		if len(g.infos) > 0 {
+			g.infos[0].logError("Error in service", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// Runs the group, as for Run.
+func (g *ServiceGroup) Run() error {
+	if len(g.infos) == 0 {
+		return fmt.Errorf("service group is empty")
+	}
+
+	for _, info := range g.infos {
+		err := info.setRunFunc()
+		if err != nil {
+			return err
+		}
+	}
+
+	primary := *g.infos[0]
+	primary.NewFunc = nil
+	primary.RunFunc = func(smgr Manager) error {
+		// Name is only defaulted once Run has started.
+		return g.runAll(primary.Name, smgr)
+	}
+	return Run(&primary)
+}
+
+func (g *ServiceGroup) runAll(groupName string, smgr Manager) error {
+	primary := g.infos[0]
+	gs := &groupState{
+		name:     groupName,
+		parent:   smgr,
+		n:        len(g.infos),
+		stopChan: make(chan struct{}),
+		dropChan: make(chan struct{}),
+		statuses: make([]string, len(g.infos)),
+	}
+
+	go func() {
+		select {
+		case <-smgr.StopChan():
+			gs.stop()
+		case <-gs.stopChan:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(g.infos))
+	for i, info := range g.infos {
+		i, info := i, info
+		name := info.Name
+		if name == "" {
+			name = fmt.Sprintf("service %d", i)
+		}
+
+		m := &groupManager{state: gs, index: i, name: name}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := primary.callRunFunc(info.RunFunc, m)
+
+			// A service which exits early must not hold up the others.
+			m.arriveDrop(false)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %v", name, err)
+				gs.stop()
+			}
+		}()
+	}
+
+	wg.Wait()
+	gs.stop()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// State shared between the Managers of the services in a ServiceGroup.
+type groupState struct {
+	name     string
+	parent   Manager
+	n        int
+	mutex    sync.Mutex
+	stopChan chan struct{}
+	stopped  bool
+
+	dropChan     chan struct{}
+	dropArrivals int
+	dropRequests int
+	dropErr      error
+
+	startCount    int
+	notReadyCount int
+	statuses      []string
+}
+
+func (gs *groupState) stop() {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	if !gs.stopped {
+		gs.stopped = true
+		close(gs.stopChan)
+	}
+}
+
+// The Manager passed to each service in a ServiceGroup.
+type groupManager struct {
+	state      *groupState
+	index      int
+	name       string
+	arrived    bool
+	started    bool
+	notReady   bool
+	goroutines goroutineGroup
+}
+
+// Records that the service has either requested that privileges be dropped
+// or exited. Once every service has done so, privileges are dropped if any
+// service requested it.
+func (m *groupManager) arriveDrop(request bool) {
+	gs := m.state
+	gs.mutex.Lock()
+	if m.arrived {
+		gs.mutex.Unlock()
+		return
+	}
+
+	m.arrived = true
+	gs.dropArrivals++
+	if request {
+		gs.dropRequests++
+	}
+
+	last := gs.dropArrivals == gs.n
+	gs.mutex.Unlock()
+
+	if last {
+		if gs.dropRequests > 0 {
+			gs.dropErr = gs.parent.DropPrivileges()
+		}
+		close(gs.dropChan)
+	}
+}
+
+func (m *groupManager) DropPrivileges() error {
+	m.arriveDrop(true)
+	<-m.state.dropChan
+	return m.state.dropErr
+}
+
+// Files are opened when privileges are dropped for the whole group, and are
+// shared by all services in the group.
+func (m *groupManager) PreDropOpen(path string, flags int) error {
+	return m.state.parent.PreDropOpen(path, flags)
+}
+
+func (m *groupManager) PreDropFiles() map[string]*os.File {
+	return m.state.parent.PreDropFiles()
+}
+
+func (m *groupManager) SetStarted() {
+	gs := m.state
+	gs.mutex.Lock()
+	if m.started {
+		gs.mutex.Unlock()
+		return
+	}
+
+	m.started = true
+	gs.startCount++
+	last := gs.startCount == gs.n
+	gs.mutex.Unlock()
+
+	if last {
+		gs.parent.SetStarted()
+	}
+}
+
+// Sets the readiness of this service. The group is ready only when every
+// service in it is ready.
+func (m *groupManager) SetReady(ready bool) {
+	gs := m.state
+	gs.mutex.Lock()
+	if m.notReady == !ready {
+		gs.mutex.Unlock()
+		return
+	}
+
+	m.notReady = !ready
+	if ready {
+		gs.notReadyCount--
+	} else {
+		gs.notReadyCount++
+	}
+	allReady := gs.notReadyCount == 0
+	gs.mutex.Unlock()
+
+	gs.parent.SetReady(allReady)
+}
+
+func (m *groupManager) IsReady() bool {
+	gs := m.state
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	return m.started && !m.notReady
+}
+
+func (m *groupManager) StopChan() <-chan struct{} {
+	return m.state.stopChan
+}
+
+func (m *groupManager) IsDraining() bool {
+	return m.state.parent.IsDraining()
+}
+
+// Sets the status of this service. The status reported for the group combines
+// the statuses of all services. The status of each service is also published
+// individually, qualified with the name of the group, as in "group/name".
+func (m *groupManager) SetStatus(status string) {
+	gs := m.state
+	publishStatus(gs.name+"/"+m.name, status)
+
+	gs.mutex.Lock()
+	gs.statuses[m.index] = status
+
+	var parts []string
+	for _, s := range gs.statuses {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	gs.mutex.Unlock()
+
+	gs.parent.SetStatus(strings.Join(parts, "; "))
+}
+
+// Sets the status of a component of this service. Components are qualified
+// with the name of the service, as in "name/component".
+func (m *groupManager) SetComponentStatus(component, status string) {
+	m.state.parent.SetComponentStatus(m.name+"/"+component, status)
+}
+
+func (m *groupManager) ClearComponentStatus(component string) {
+	m.state.parent.ClearComponentStatus(m.name + "/" + component)
+}
+
+// Returns the status history of the whole group.
+func (m *groupManager) StatusHistory(n int) []StatusEntry {
+	return m.state.parent.StatusHistory(n)
+}
+
+func (m *groupManager) GetCredential(name string) ([]byte, error) {
+	return m.state.parent.GetCredential(name)
+}
+
+func (m *groupManager) SocketListeners() []net.Listener {
+	return m.state.parent.SocketListeners()
+}
+
+// Restarts the whole process, and therefore every service in the group.
+func (m *groupManager) Restart() error {
+	return m.state.parent.Restart()
+}
+
+func (m *groupManager) InheritedFiles() []*os.File {
+	return m.state.parent.InheritedFiles()
+}
+
+func (m *groupManager) RuntimeDir() string {
+	return m.state.parent.RuntimeDir()
+}
+
+func (m *groupManager) StateDir() string {
+	return m.state.parent.StateDir()
+}
+
+func (m *groupManager) CacheDir() string {
+	return m.state.parent.CacheDir()
+}
+
+func (m *groupManager) LogDir() string {
+	return m.state.parent.LogDir()
+}
+
+func (m *groupManager) Go(f func(ctx context.Context)) error {
+	return m.goroutines.Go(m.state.stopChan, f)
+}
+
+func (m *groupManager) Wait() error {
+	return m.goroutines.Wait()
+}
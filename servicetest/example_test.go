@@ -0,0 +1,47 @@
+package servicetest_test
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/servicetest"
+)
+
+func runFoobar(smgr service.Manager) error {
+	err := smgr.DropPrivileges()
+	if err != nil {
+		return err
+	}
+
+	smgr.SetStatus("foobar: running ok")
+	smgr.SetStarted()
+
+	<-smgr.StopChan()
+	return nil
+}
+
+// The following example illustrates how to unit test a RunFunc using
+// MockManager.
+func ExampleMockManager() {
+	m := &servicetest.MockManager{}
+
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- runFoobar(m)
+	}()
+
+	if !m.WaitStarted(5 * time.Second) {
+		fmt.Println("service did not start")
+		return
+	}
+
+	fmt.Println(m.LastStatus())
+
+	m.TriggerStop()
+	fmt.Println(<-doneChan)
+
+	// Output:
+	// foobar: running ok
+	// <nil>
+}
@@ -0,0 +1,100 @@
+package servicetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/hlandau/service.v3"
+)
+
+// A service started by Run.
+type ServiceRun struct {
+	// The Manager passed to the service. It can be used to inspect the calls
+	// made by the service.
+	Manager *MockManager
+
+	doneChan chan struct{}
+	mutex    sync.Mutex
+	err      error
+}
+
+// Runs the service described by info in a goroutine with a MockManager, as
+// by service.RunWithManager, and waits for it to call SetStarted. The test
+// fails if the service does not start within the timeout or exits before
+// starting.
+//
+// When the test finishes, the service is stopped if it is still running, and
+// the test fails if it does not stop within the timeout.
+func Run(t testing.TB, info *service.Info, timeout time.Duration) *ServiceRun {
+	t.Helper()
+
+	r := &ServiceRun{
+		Manager:  &MockManager{},
+		doneChan: make(chan struct{}),
+	}
+
+	go func() {
+		err := service.RunWithManager(info, r.Manager)
+
+		r.mutex.Lock()
+		r.err = err
+		r.mutex.Unlock()
+		close(r.doneChan)
+	}()
+
+	t.Cleanup(func() {
+		r.Stop()
+		select {
+		case <-r.doneChan:
+		case <-time.After(timeout):
+			t.Errorf("service %s did not stop within %v", info.Name, timeout)
+		}
+	})
+
+	m := r.Manager
+	m.init()
+	select {
+	case <-m.startedChan:
+	case <-r.doneChan:
+		select {
+		case <-m.startedChan:
+		default:
+			t.Fatalf("service %s exited before starting: %v", info.Name, r.Err())
+		}
+	case <-time.After(timeout):
+		t.Fatalf("service %s did not start within %v", info.Name, timeout)
+	}
+
+	return r
+}
+
+// Asks the service to stop. It does not wait for it to do so; see WaitStop.
+func (r *ServiceRun) Stop() {
+	r.Manager.TriggerStop()
+}
+
+// Waits for the service to exit and returns the error it returned. Returns
+// an error if it does not exit within the timeout.
+func (r *ServiceRun) WaitStop(timeout time.Duration) error {
+	select {
+	case <-r.doneChan:
+		return r.Err()
+	case <-time.After(timeout):
+		return fmt.Errorf("service did not stop within %v", timeout)
+	}
+}
+
+// Returns the status most recently set by the service.
+func (r *ServiceRun) LastStatus() string {
+	return r.Manager.LastStatus()
+}
+
+// Returns the error returned by the service, or nil if it has not exited or
+// exited successfully.
+func (r *ServiceRun) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
@@ -0,0 +1,419 @@
+// Package servicetest provides utilities for testing services written using
+// gopkg.in/hlandau/service.v3.
+package servicetest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"gopkg.in/hlandau/service.v3"
+)
+
+// An implementation of service.Manager for use when unit testing a RunFunc.
+// The zero value is ready to use.
+//
+// By default, DropPrivileges does nothing and succeeds, and SetStarted and
+// SetStatus record the fact that they were called. The behaviour of each can
+// be overridden by setting the corresponding field; the recording still takes
+// place.
+type MockManager struct {
+	// If set, called by DropPrivileges, which returns its result.
+	DropPrivilegesFunc func() error
+
+	// If set, called by the first call to SetStarted.
+	SetStartedFunc func()
+
+	// If set, called by SetStatus.
+	SetStatusFunc func(status string)
+
+	// Returned by SocketListeners.
+	Listeners []net.Listener
+
+	// Credentials returned by GetCredential, keyed by name.
+	Credentials map[string][]byte
+
+	// Returned by RuntimeDir, StateDir, CacheDir and LogDir respectively.
+	RuntimeDirPath string
+	StateDirPath   string
+	CacheDirPath   string
+	LogDirPath     string
+
+	mutex           sync.Mutex
+	initOnce        sync.Once
+	stopChan        chan struct{}
+	startedChan     chan struct{}
+	stopped         bool
+	draining        bool
+	started         bool
+	notReady        bool
+	dropped         bool
+	dropSucceeded   bool
+	preDropRequests []preDropRequest
+	preDropFiles    map[string]*os.File
+	status          string
+	components      map[string]string
+	history         []service.StatusEntry
+	restarts        int
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+	waiting         bool
+	goErr           error
+}
+
+var _ service.Manager = (*MockManager)(nil)
+
+type preDropRequest struct {
+	path  string
+	flags int
+}
+
+func (m *MockManager) init() {
+	m.initOnce.Do(func() {
+		m.stopChan = make(chan struct{})
+		m.startedChan = make(chan struct{})
+		m.ctx, m.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// Once DropPrivileges has succeeded, further calls return nil without calling
+// DropPrivilegesFunc, as for the real Manager.
+func (m *MockManager) DropPrivileges() error {
+	m.mutex.Lock()
+	m.dropped = true
+	if m.dropSucceeded {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.mutex.Unlock()
+
+	err := m.openPreDropFiles()
+	if err != nil {
+		return err
+	}
+
+	if m.DropPrivilegesFunc != nil {
+		err := m.DropPrivilegesFunc()
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	m.dropSucceeded = true
+	m.mutex.Unlock()
+	return nil
+}
+
+// Records a request to open a file. Requested files are opened by the first
+// successful call to DropPrivileges, before DropPrivilegesFunc is called.
+func (m *MockManager) PreDropOpen(path string, flags int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.dropSucceeded {
+		return fmt.Errorf("cannot open %s before dropping privileges: privileges already dropped", path)
+	}
+
+	for _, r := range m.preDropRequests {
+		if r.path == path {
+			if r.flags != flags {
+				return fmt.Errorf("cannot open %s before dropping privileges: already requested with different flags", path)
+			}
+			return nil
+		}
+	}
+
+	m.preDropRequests = append(m.preDropRequests, preDropRequest{path, flags})
+	return nil
+}
+
+// Returns the files opened by DropPrivileges, keyed by path.
+func (m *MockManager) PreDropFiles() map[string]*os.File {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	files := make(map[string]*os.File, len(m.preDropFiles))
+	for path, f := range m.preDropFiles {
+		files[path] = f
+	}
+
+	return files
+}
+
+func (m *MockManager) openPreDropFiles() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.preDropFiles == nil {
+		m.preDropFiles = map[string]*os.File{}
+	}
+
+	for _, r := range m.preDropRequests {
+		if _, ok := m.preDropFiles[r.path]; ok {
+			continue
+		}
+
+		f, err := os.OpenFile(r.path, r.flags, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot open %s before dropping privileges: %v", r.path, err)
+		}
+
+		m.preDropFiles[r.path] = f
+	}
+
+	return nil
+}
+
+func (m *MockManager) SetStarted() {
+	m.init()
+
+	m.mutex.Lock()
+	if m.started {
+		m.mutex.Unlock()
+		return
+	}
+	m.started = true
+	close(m.startedChan)
+	m.mutex.Unlock()
+
+	if m.SetStartedFunc != nil {
+		m.SetStartedFunc()
+	}
+}
+
+// Records the readiness of the service; see IsReady.
+func (m *MockManager) SetReady(ready bool) {
+	m.mutex.Lock()
+	m.notReady = !ready
+	m.mutex.Unlock()
+}
+
+// Returns true if SetStarted has been called and SetReady(false) has not been
+// called since, or has been followed by SetReady(true).
+func (m *MockManager) IsReady() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.started && !m.notReady
+}
+
+func (m *MockManager) StopChan() <-chan struct{} {
+	m.init()
+	return m.stopChan
+}
+
+// Returns true if TriggerDrain or TriggerStop has been called.
+func (m *MockManager) IsDraining() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.draining || m.stopped
+}
+
+func (m *MockManager) SetStatus(status string) {
+	m.mutex.Lock()
+	m.status = status
+	m.history = append(m.history, service.StatusEntry{Time: time.Now(), Status: status})
+	m.mutex.Unlock()
+
+	if m.SetStatusFunc != nil {
+		m.SetStatusFunc(status)
+	}
+}
+
+func (m *MockManager) SetComponentStatus(component, status string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.components == nil {
+		m.components = map[string]string{}
+	}
+	m.components[component] = status
+	m.history = append(m.history, service.StatusEntry{Time: time.Now(), Status: status, Component: component})
+}
+
+func (m *MockManager) ClearComponentStatus(component string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.components, component)
+}
+
+// Returns up to n of the most recent statuses, oldest first. All statuses are
+// kept.
+func (m *MockManager) StatusHistory(n int) []service.StatusEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if n > len(m.history) {
+		n = len(m.history)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	return append([]service.StatusEntry(nil), m.history[len(m.history)-n:]...)
+}
+
+// Returns the named credential from Credentials, or an error if it is not
+// present.
+func (m *MockManager) GetCredential(name string) ([]byte, error) {
+	b, ok := m.Credentials[name]
+	if !ok {
+		return nil, fmt.Errorf("credential %q not found", name)
+	}
+
+	return b, nil
+}
+
+func (m *MockManager) SocketListeners() []net.Listener {
+	return m.Listeners
+}
+
+// Records the request and stops the service, as for TriggerStop.
+func (m *MockManager) Restart() error {
+	m.mutex.Lock()
+	m.restarts++
+	m.mutex.Unlock()
+
+	m.TriggerStop()
+	return nil
+}
+
+func (m *MockManager) InheritedFiles() []*os.File {
+	return nil
+}
+
+// Starts f in a new goroutine with a context which is cancelled by
+// TriggerStop.
+func (m *MockManager) Go(f func(ctx context.Context)) error {
+	m.init()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.waiting {
+		return fmt.Errorf("cannot start goroutine after Wait has been called")
+	}
+
+	if m.stopped {
+		return fmt.Errorf("service is stopping")
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				m.mutex.Lock()
+				if m.goErr == nil {
+					m.goErr = &service.PanicError{Value: r, Stack: debug.Stack()}
+				}
+				m.mutex.Unlock()
+			}
+		}()
+
+		f(m.ctx)
+	}()
+
+	return nil
+}
+
+// Waits for all goroutines started by Go to return.
+func (m *MockManager) Wait() error {
+	m.mutex.Lock()
+	m.waiting = true
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.goErr
+}
+
+func (m *MockManager) RuntimeDir() string {
+	return m.RuntimeDirPath
+}
+
+func (m *MockManager) StateDir() string {
+	return m.StateDirPath
+}
+
+func (m *MockManager) CacheDir() string {
+	return m.CacheDirPath
+}
+
+func (m *MockManager) LogDir() string {
+	return m.LogDirPath
+}
+
+// Marks the service as draining without closing the stop channel, as happens
+// during Config.DrainTimeout.
+func (m *MockManager) TriggerDrain() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.draining = true
+}
+
+// Closes the stop channel, asking the service to stop. Calling TriggerStop
+// more than once has no further effect.
+func (m *MockManager) TriggerStop() {
+	m.init()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.stopped {
+		m.stopped = true
+		close(m.stopChan)
+		m.cancel()
+	}
+}
+
+// Waits until SetStarted has been called. Returns false if the timeout
+// expires first.
+func (m *MockManager) WaitStarted(timeout time.Duration) bool {
+	m.init()
+
+	select {
+	case <-m.startedChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Returns the status most recently passed to SetStatus.
+func (m *MockManager) LastStatus() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.status
+}
+
+// Returns the status most recently passed to SetComponentStatus for the given
+// component, and whether it is set.
+func (m *MockManager) ComponentStatus(component string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	status, ok := m.components[component]
+	return status, ok
+}
+
+// Returns true if DropPrivileges has been called.
+func (m *MockManager) Dropped() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.dropped
+}
+
+// Returns the number of times Restart has been called.
+func (m *MockManager) Restarts() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.restarts
+}
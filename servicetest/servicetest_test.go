@@ -0,0 +1,59 @@
+package servicetest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/hlandau/service.v3/servicetest"
+)
+
+func TestSetStartedTwice(t *testing.T) {
+	calls := 0
+	m := &servicetest.MockManager{
+		SetStartedFunc: func() { calls++ },
+	}
+
+	m.SetStarted()
+	m.SetStarted()
+
+	if calls != 1 {
+		t.Fatalf("SetStartedFunc called %d times, expected 1", calls)
+	}
+	if !m.WaitStarted(time.Second) {
+		t.Fatalf("service not recorded as started")
+	}
+	if !m.IsReady() {
+		t.Fatalf("service not ready after SetStarted")
+	}
+}
+
+func TestDropPrivilegesTwice(t *testing.T) {
+	calls := 0
+	m := &servicetest.MockManager{
+		DropPrivilegesFunc: func() error {
+			calls++
+			if calls == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	}
+
+	if m.DropPrivileges() == nil {
+		t.Fatalf("DropPrivileges succeeded, expected failure")
+	}
+	for i := 0; i < 2; i++ {
+		err := m.DropPrivileges()
+		if err != nil {
+			t.Fatalf("DropPrivileges: %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatalf("DropPrivilegesFunc called %d times, expected 2", calls)
+	}
+	if !m.Dropped() {
+		t.Fatalf("DropPrivileges not recorded")
+	}
+}
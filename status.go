@@ -0,0 +1,119 @@
+package service
+
+import (
+	"strings"
+	"time"
+)
+
+// The statuses of the components of a service, as set by
+// Manager.SetComponentStatus, in the order in which they were first set.
+type componentStatuses struct {
+	names    []string
+	statuses map[string]string
+}
+
+func (c *componentStatuses) set(component, status string) {
+	if c.statuses == nil {
+		c.statuses = map[string]string{}
+	}
+
+	if _, ok := c.statuses[component]; !ok {
+		c.names = append(c.names, component)
+	}
+
+	c.statuses[component] = status
+}
+
+func (c *componentStatuses) clear(component string) {
+	if _, ok := c.statuses[component]; !ok {
+		return
+	}
+
+	delete(c.statuses, component)
+	for i, name := range c.names {
+		if name == component {
+			c.names = append(c.names[:i], c.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns the overall status of the service, which consists of the given
+// status followed by the status of each component, separated by "; ".
+func (c *componentStatuses) join(status string) string {
+	var parts []string
+	if status != "" {
+		parts = append(parts, status)
+	}
+
+	for _, name := range c.names {
+		parts = append(parts, name+": "+c.statuses[name])
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// A status message recorded in the status history of a service. See
+// Manager.StatusHistory.
+type StatusEntry struct {
+	Time      time.Time // When the status was set.
+	Status    string    // The status.
+	Component string    // The component, if set by SetComponentStatus.
+}
+
+// The default value of Config.StatusHistorySize.
+const defaultStatusHistorySize = 100
+
+// A fixed-size ring buffer of status entries.
+type statusHistory struct {
+	entries []StatusEntry
+	next    int
+	full    bool
+}
+
+// Records an entry. size is the maximum number of entries kept, as for
+// Config.StatusHistorySize.
+func (h *statusHistory) add(size int, entry StatusEntry) {
+	if size == 0 {
+		size = defaultStatusHistorySize
+	}
+	if size < 0 {
+		return
+	}
+
+	if h.entries == nil {
+		h.entries = make([]StatusEntry, size)
+	}
+
+	h.entries[h.next] = entry
+	h.next++
+	if h.next == len(h.entries) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// Returns up to n of the most recent entries, oldest first.
+func (h *statusHistory) last(n int) []StatusEntry {
+	count := h.next
+	if h.full {
+		count = len(h.entries)
+	}
+	if n > count {
+		n = count
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	entries := make([]StatusEntry, n)
+	start := h.next - n
+	if start < 0 {
+		start += len(h.entries)
+	}
+	for i := range entries {
+		entries[i] = h.entries[(start+i)%len(h.entries)]
+	}
+
+	return entries
+}
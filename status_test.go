@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"sync"
+	"testing"
+)
+
+// SetStatus may be called from any goroutine while the main loop reports the
+// status. Run with -race.
+func TestStatusConcurrent(t *testing.T) {
+	h := &ihandler{
+		info:             &Info{Name: "test"},
+		statusNotifyChan: make(chan struct{}, 1),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.SetStatus("status")
+				h.SetComponentStatus("component", "status")
+				h.ClearComponentStatus("component")
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-h.statusNotifyChan:
+			h.updateStatus()
+		case <-done:
+			if s := h.fullStatus(); s != "status" {
+				t.Fatalf("status is %q, expected %q", s, "status")
+			}
+			if n := len(h.StatusHistory(1000)); n != 100 {
+				t.Fatalf("status history has %d entries, expected 100", n)
+			}
+			return
+		}
+	}
+}
+
+// Each service publishes its status under its own name.
+func TestStatusVarKeyed(t *testing.T) {
+	for _, name := range []string{"keyed-a", "keyed-b"} {
+		h := &ihandler{
+			info:             &Info{Name: name},
+			statusNotifyChan: make(chan struct{}, 1),
+		}
+		h.SetStatus(name + " status")
+	}
+
+	for _, name := range []string{"keyed-a", "keyed-b"} {
+		v := statusVar.Get(name)
+		if v == nil {
+			t.Fatalf("no status published for %q", name)
+		}
+		if s := v.(*expvar.String).Value(); s != name+" status" {
+			t.Fatalf("status of %q is %q, expected %q", name, s, name+" status")
+		}
+	}
+}
+
+// A ServiceBackend whose Status method returns fixed results.
+type statusBackend struct {
+	state ServiceState
+	err   error
+}
+
+func (b *statusBackend) Install(info *Info) error { return nil }
+func (b *statusBackend) Remove(info *Info) error  { return nil }
+func (b *statusBackend) Start(info *Info) error   { return nil }
+func (b *statusBackend) Stop(info *Info) error    { return nil }
+
+func (b *statusBackend) Status(info *Info) (ServiceState, error) {
+	return b.state, b.err
+}
+
+// A failure to query the backend must not be reported as the service being
+// stopped.
+func TestPrintStatusExitCode(t *testing.T) {
+	tests := []struct {
+		backend  statusBackend
+		exitCode int
+	}{
+		{statusBackend{state: StateRunning}, 0},
+		{statusBackend{state: StateStopped}, 1},
+		{statusBackend{state: StateStartPending}, 2},
+		{statusBackend{err: errors.New("no such service")}, 3},
+	}
+
+	for _, tt := range tests {
+		err := printStatus(io.Discard, &tt.backend, &Info{Name: "test"})
+
+		exitCode := 0
+		var se *statusError
+		if errors.As(err, &se) {
+			exitCode = se.exitCode()
+		} else if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tt.backend, err)
+		}
+
+		if exitCode != tt.exitCode {
+			t.Errorf("%+v: exit code %d, expected %d", tt.backend, exitCode, tt.exitCode)
+		}
+	}
+}
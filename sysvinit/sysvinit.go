@@ -0,0 +1,167 @@
+// Package sysvinit generates SysV init scripts for services.
+//
+// Importing this package registers GenerateScript as the service file
+// generator for "sysvinit", enabling the "sysvinit" service command, which
+// installs an init script for the service in /etc/init.d.
+package sysvinit
+
+import (
+	"bufio"
+	"io"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+func init() {
+	service.RegisterGenerator("sysvinit", GenerateScript)
+}
+
+// Writes a POSIX shell SysV init script with LSB headers for the service
+// described by info. The script supports the start, stop, restart and status
+// actions.
+//
+// The service is started in the background using start-stop-daemon where
+// available (e.g. on Debian), and otherwise using the daemon function from
+// /etc/rc.d/init.d/functions (e.g. on RHEL), with the settings it was
+// installed with; see service.Config.Args. If Config.PIDFile is set, the
+// service is expected to write it; otherwise, the script writes a PID file in
+// /var/run. If Config.UID is set, the service is started as that user (and
+// Config.GID, if set), unless it must chroot, in which case it is started as
+// root and drops privileges itself.
+func GenerateScript(w io.Writer, info *service.Info) error {
+	bw := bufio.NewWriter(w)
+
+	title := info.Title
+	if title == "" {
+		title = info.Name
+	}
+
+	description := info.Description
+	if description == "" {
+		description = title
+	}
+
+	pidFile := info.Config.PIDFile
+	makePIDFile := "no"
+	if pidFile == "" {
+		pidFile = "/var/run/" + info.Name + ".pid"
+		makePIDFile = "yes"
+	}
+
+	bw.WriteString("#!/bin/sh\n")
+	bw.WriteString("### BEGIN INIT INFO\n")
+	bw.WriteString("# Provides:          " + info.Name + "\n")
+	bw.WriteString("# Required-Start:    $remote_fs $network $syslog\n")
+	bw.WriteString("# Required-Stop:     $remote_fs $network $syslog\n")
+	bw.WriteString("# Default-Start:     2 3 4 5\n")
+	bw.WriteString("# Default-Stop:      0 1 6\n")
+	bw.WriteString("# Short-Description: " + svcfile.SingleLine(title) + "\n")
+	bw.WriteString("# Description:       " + svcfile.SingleLine(description) + "\n")
+	bw.WriteString("### END INIT INFO\n\n")
+
+	cmd := svcfile.Command(info)
+	uid, gid := svcfile.User(info)
+
+	bw.WriteString("NAME=" + svcfile.ShellQuote(info.Name) + "\n")
+	bw.WriteString("DAEMON=" + svcfile.ShellQuote(cmd[0]) + "\n")
+	bw.WriteString("DAEMON_ARGS=" + svcfile.ShellQuote(svcfile.ShellJoin(cmd[1:])) + "\n")
+	bw.WriteString("PIDFILE=" + svcfile.ShellQuote(pidFile) + "\n")
+	bw.WriteString("MAKE_PIDFILE=" + makePIDFile + "\n")
+	bw.WriteString("RUN_USER=" + svcfile.ShellQuote(uid) + "\n")
+	bw.WriteString("RUN_GROUP=" + svcfile.ShellQuote(gid) + "\n")
+
+	bw.WriteString(script)
+
+	return bw.Flush()
+}
+
+const script = `
+[ -x "$DAEMON" ] || exit 5
+
+if [ -f /etc/rc.d/init.d/functions ]; then
+	. /etc/rc.d/init.d/functions
+fi
+
+have_ssd() {
+	command -v start-stop-daemon >/dev/null 2>&1
+}
+
+is_running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+}
+
+do_start() {
+	if is_running; then
+		echo "$NAME is already running"
+		return 0
+	fi
+
+	if have_ssd; then
+		CHUID=
+		if [ -n "$RUN_USER" ]; then
+			CHUID="$RUN_USER${RUN_GROUP:+:$RUN_GROUP}"
+		fi
+		MAKE=
+		if [ "$MAKE_PIDFILE" = yes ]; then
+			MAKE=--make-pidfile
+		fi
+		eval "set -- $DAEMON_ARGS"
+		start-stop-daemon --start --quiet --background $MAKE --pidfile "$PIDFILE" \
+			${CHUID:+--chuid "$CHUID"} --exec "$DAEMON" -- "$@"
+	else
+		if [ "$MAKE_PIDFILE" = yes ]; then
+			touch "$PIDFILE"
+			if [ -n "$RUN_USER" ]; then
+				chown "$RUN_USER" "$PIDFILE"
+			fi
+			CMD="\"$DAEMON\" $DAEMON_ARGS </dev/null >/dev/null 2>&1 & echo \$! > \"$PIDFILE\""
+		else
+			CMD="\"$DAEMON\" $DAEMON_ARGS </dev/null >/dev/null 2>&1 &"
+		fi
+		daemon --pidfile="$PIDFILE" ${RUN_USER:+--user="$RUN_USER"} "$CMD"
+	fi
+}
+
+do_stop() {
+	if have_ssd; then
+		start-stop-daemon --stop --quiet --retry TERM/30/KILL/5 --pidfile "$PIDFILE" --exec "$DAEMON"
+	else
+		killproc -p "$PIDFILE" "$DAEMON"
+	fi
+	RC=$?
+	if [ "$MAKE_PIDFILE" = yes ]; then
+		rm -f "$PIDFILE"
+	fi
+	return $RC
+}
+
+case "$1" in
+	start)
+		echo "Starting $NAME"
+		do_start
+		;;
+	stop)
+		echo "Stopping $NAME"
+		do_stop
+		;;
+	restart)
+		echo "Restarting $NAME"
+		do_stop
+		do_start
+		;;
+	status)
+		if is_running; then
+			echo "$NAME is running"
+			exit 0
+		else
+			echo "$NAME is not running"
+			exit 3
+		fi
+		;;
+	*)
+		echo "Usage: $0 {start|stop|restart|status}" >&2
+		exit 2
+		;;
+esac
+`
@@ -0,0 +1,144 @@
+// Package unit generates systemd unit files for services.
+//
+// Importing this package registers GenerateUnit as the generator used by
+// service.SystemdBackend, enabling services to be installed under systemd
+// using the "install" and "install-unit" service commands.
+package unit
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+func init() {
+	service.RegisterGenerator("systemd", GenerateUnit)
+}
+
+// Writes a systemd unit file for the service described by info.
+//
+// The service is started with the settings it was installed with; see
+// service.Config.Args. The unit uses Type=notify, as services using this
+// package support the systemd notify protocol, and is restarted on failure. If
+// Config.UID or Config.GID is set, the service is run as that user or group,
+// unless it must chroot, in which case it is started as root and drops
+// privileges itself. If the service would be chrooted, indicating that it does
+// not need access to the filesystem, systemd's filesystem protections are
+// enabled, except for the directories of any PID files. NoNewPrivileges is set
+// unless NoBanSuid is set.
+func GenerateUnit(w io.Writer, info *service.Info) error {
+	bw := bufio.NewWriter(w)
+
+	title := info.Title
+	if title == "" {
+		title = info.Name
+	}
+
+	bw.WriteString("[Unit]\n")
+	writeKey(bw, "Description", title)
+	if info.Description != "" && info.Description != title {
+		writeComment(bw, info.Description)
+	}
+	writeKey(bw, "After", "network.target")
+
+	bw.WriteString("\n[Service]\n")
+	writeKey(bw, "Type", "notify")
+	writeRawKey(bw, "ExecStart", commandLine(svcfile.Command(info)))
+	writeKey(bw, "Restart", "on-failure")
+
+	uid, gid := svcfile.User(info)
+	if uid != "" {
+		writeKey(bw, "User", uid)
+	}
+	if gid != "" {
+		writeKey(bw, "Group", gid)
+	}
+	if info.Config.PIDFile != "" {
+		writeKey(bw, "PIDFile", info.Config.PIDFile)
+	}
+
+	if !info.NoBanSuid {
+		writeKey(bw, "NoNewPrivileges", "yes")
+	}
+	if svcfile.Chroots(info) {
+		writeKey(bw, "PrivateTmp", "yes")
+		writeKey(bw, "ProtectSystem", "strict")
+		writeKey(bw, "ProtectHome", "yes")
+		for _, dir := range pidFileDirs(info) {
+			writeKey(bw, "ReadWritePaths", quote(dir))
+		}
+	}
+
+	bw.WriteString("\n[Install]\n")
+	writeKey(bw, "WantedBy", "multi-user.target")
+
+	return bw.Flush()
+}
+
+// Returns the directories containing the PID files written by the service.
+func pidFileDirs(info *service.Info) []string {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, fn := range append([]string{info.Config.PIDFile}, info.Config.ExtraPIDFiles...) {
+		if fn == "" {
+			continue
+		}
+
+		dir := filepath.Dir(fn)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+func writeKey(bw *bufio.Writer, key, value string) {
+	writeRawKey(bw, key, svcfile.SingleLine(value))
+}
+
+// Writes a key whose value is already a single line.
+func writeRawKey(bw *bufio.Writer, key, value string) {
+	bw.WriteString(key)
+	bw.WriteString("=")
+	bw.WriteString(escapeSpecifiers(value))
+	bw.WriteString("\n")
+}
+
+func writeComment(bw *bufio.Writer, text string) {
+	bw.WriteString("# ")
+	bw.WriteString(svcfile.SingleLine(text))
+	bw.WriteString("\n")
+}
+
+// Escapes "%", which introduces a specifier in unit files.
+func escapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// Quotes each word of a command line as necessary and joins them. Whitespace
+// in each word is collapsed first, as a unit file line cannot contain newlines,
+// leaving the spacing between words to the quoting.
+func commandLine(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = quote(svcfile.SingleLine(w))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Quotes a command line word if it contains characters which systemd would
+// otherwise interpret.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$") {
+		return s
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,30 @@
+package unit
+
+import (
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile/svcfiletest"
+)
+
+func TestGenerateUnit(t *testing.T) {
+	base := service.Info{
+		Name:        "example",
+		Title:       "Example Service",
+		Description: "An example\nservice.",
+		ExePath:     "/usr/local/bin/example",
+	}
+
+	svcfiletest.GoldenTest(t, base, ".service", []svcfiletest.Case{
+		{Name: "simple"},
+		{Name: "user", Args: []string{"-uid=example", "-gid=example", "-pid-file=/run/example/example.pid",
+			"-set-env=GREETING=hello world", "-set-env=RATE=100%", "-command=install"}},
+		{Name: "chroot", Args: []string{"-uid=example", "-chroot=/var/empty", "-pid-file=/run/example.pid"}},
+		// Settings made in code rather than by flags must also be passed on.
+		{Name: "code", Setup: func(info *service.Info) {
+			info.Config.UID = "example"
+			info.Config.Chroot = "/var/empty"
+			info.Config.SetEnv = map[string]string{"MOTD": "hello  \n world"}
+		}},
+	}, GenerateUnit)
+}
@@ -0,0 +1,69 @@
+// Package upstart generates Upstart job files for services.
+//
+// Importing this package registers GenerateJob as the service file generator
+// for "upstart".
+package upstart
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile"
+)
+
+func init() {
+	service.RegisterGenerator("upstart", GenerateJob)
+}
+
+// Writes an Upstart job (.conf) file for the service described by info.
+//
+// The job is started in runlevels 2-5, with the settings the service was
+// installed with (see service.Config.Args), and respawned if it exits. If
+// Config.UID or Config.GID is set, the job runs as that user or group, unless
+// the service must chroot, in which case it is started as root and drops
+// privileges itself. If
+// Config.PIDFile is set, the pre-start stanza ensures that its directory
+// exists and the post-stop stanza removes any stale PID file.
+func GenerateJob(w io.Writer, info *service.Info) error {
+	bw := bufio.NewWriter(w)
+
+	title := info.Title
+	if title == "" {
+		title = info.Name
+	}
+
+	bw.WriteString("description " + quoteStanza(svcfile.SingleLine(title)) + "\n\n")
+	bw.WriteString("start on runlevel [2345]\n")
+	bw.WriteString("stop on runlevel [!2345]\n\n")
+	bw.WriteString("respawn\n\n")
+
+	uid, gid := svcfile.User(info)
+	if uid != "" {
+		bw.WriteString("setuid " + uid + "\n")
+	}
+	if gid != "" {
+		bw.WriteString("setgid " + gid + "\n")
+	}
+
+	if pidFile := info.Config.PIDFile; pidFile != "" {
+		bw.WriteString("\npre-start script\n")
+		bw.WriteString("\tmkdir -p " + svcfile.ShellQuote(filepath.Dir(pidFile)) + "\n")
+		bw.WriteString("end script\n")
+		bw.WriteString("\npost-stop script\n")
+		bw.WriteString("\trm -f " + svcfile.ShellQuote(pidFile) + "\n")
+		bw.WriteString("end script\n")
+	}
+
+	bw.WriteString("\nexec " + svcfile.ShellJoin(svcfile.Command(info)) + "\n")
+
+	return bw.Flush()
+}
+
+// Quotes a string for use as a stanza argument.
+func quoteStanza(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,23 @@
+package upstart
+
+import (
+	"testing"
+
+	"gopkg.in/hlandau/service.v3"
+	"gopkg.in/hlandau/service.v3/internal/svcfile/svcfiletest"
+)
+
+func TestGenerateJob(t *testing.T) {
+	base := service.Info{
+		Name:    "example",
+		Title:   "Example\nService",
+		ExePath: "/usr/local/bin/example",
+	}
+
+	svcfiletest.GoldenTest(t, base, ".conf", []svcfiletest.Case{
+		{Name: "simple"},
+		{Name: "user", Args: []string{"-uid=example", "-gid=example", "-pid-file=/run/example.pid",
+			"-set-env=GREETING=it's $HOME", "-command=install"}},
+		{Name: "chroot", Args: []string{"-uid=example", "-chroot=/var/empty"}},
+	}, GenerateJob)
+}
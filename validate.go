@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// The maximum length of a service name or display name on Windows.
+const maxServiceNameLen = 256
+
+// Checks the configuration for errors and inconsistencies which would
+// otherwise only be detected partway through starting the service. Returns
+// all of the problems found, or nil if there are none.
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if c.Chroot != "" && c.Chroot != "/" && c.UID == "" {
+		errs = append(errs, fmt.Errorf("Chroot is set but UID is not; chrooting only occurs when dropping privileges"))
+	}
+
+	if c.UID != "" && c.GID == "" {
+		err := checkUserGroup(c.UID)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.CPUProfile != "" {
+		err := checkFileDir(c.CPUProfile, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CPUProfile: %v", err))
+		}
+	}
+
+	// The PID file directory may not exist yet if it is created as the
+	// runtime directory.
+	if c.PIDFile != "" {
+		err := checkFileDir(c.PIDFile, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PIDFile: %v", err))
+		}
+	}
+
+	// There is no way on Windows to restrict a Unix domain socket to the
+	// service user, and no named pipe equivalent is implemented.
+	if c.ManagementSocket != "" && !UsingPlatform("unix") {
+		errs = append(errs, fmt.Errorf("ManagementSocket is only supported on UNIX"))
+	}
+
+	err := checkHealthCheckFailureAction(c.HealthCheckFailureAction)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// Checks that the directory containing the given file is writable. If
+// mustExist is false, a missing directory is not an error.
+func checkFileDir(path string, mustExist bool) error {
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return nil
+		}
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if !isWritable(dir) {
+		return fmt.Errorf("directory %s is not writable", dir)
+	}
+
+	return nil
+}
+
+// Checks the Info for errors, such as names which are not valid on the
+// current platform. Returns all of the problems found, or nil if there are
+// none.
+func (info *Info) Validate() []error {
+	var errs []error
+
+	if info.Name == "" {
+		errs = append(errs, fmt.Errorf("service name must be specified"))
+	} else if strings.ContainsAny(info.Name, `/\`) {
+		errs = append(errs, fmt.Errorf("service name must not contain slashes: %q", info.Name))
+	}
+
+	if utf8.RuneCountInString(info.Name) > maxServiceNameLen {
+		errs = append(errs, fmt.Errorf("service name is longer than %d characters", maxServiceNameLen))
+	}
+
+	if utf8.RuneCountInString(info.Title) > maxServiceNameLen {
+		errs = append(errs, fmt.Errorf("service title is longer than %d characters", maxServiceNameLen))
+	}
+
+	// RunFunc takes precedence if both are set.
+	if info.RunFunc == nil && info.NewFunc == nil {
+		errs = append(errs, fmt.Errorf("either RunFunc or NewFunc must be specified"))
+	}
+
+	return errs
+}
+
+// Returns descriptions of settings which are likely to be mistakes but which
+// do not prevent the service from running.
+func (info *Info) warnings() []string {
+	var warnings []string
+
+	if info.Config.GID != "" && info.Config.UID == "" {
+		warnings = append(warnings, "GID is set but UID is not; privileges are only dropped if UID is set")
+	}
+
+	if strings.ContainsAny(info.Description, "\r\n") {
+		warnings = append(warnings, "service description should be a single line")
+	}
+
+	return warnings
+}
+
+// Validates the Info and its Config, returning a single error combining all
+// problems found. Warnings are logged.
+func (info *Info) validate() error {
+	for _, w := range info.warnings() {
+		info.logger().Warn(w, "service", info.Name)
+	}
+
+	errs := append(info.Validate(), info.Config.Validate()...)
+	return errors.Join(errs...)
+}